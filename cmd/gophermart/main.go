@@ -1,15 +1,29 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/nu-kotov/gophermart/internal/auth"
 	"github.com/nu-kotov/gophermart/internal/config"
 	"github.com/nu-kotov/gophermart/internal/handler"
 	"github.com/nu-kotov/gophermart/internal/logger"
+	"github.com/nu-kotov/gophermart/internal/middleware"
+	"github.com/nu-kotov/gophermart/internal/notifier"
+	"github.com/nu-kotov/gophermart/internal/oidc"
 	"github.com/nu-kotov/gophermart/internal/storage"
+	"github.com/nu-kotov/gophermart/internal/storage/dberrors"
+	"github.com/nu-kotov/gophermart/internal/storage/postgres"
+	"github.com/nu-kotov/gophermart/internal/validation"
 )
 
 func main() {
@@ -31,6 +45,28 @@ func run() error {
 		return err
 	}
 
+	keys, err := auth.LoadKeySet(config.SigningKeysFile, config.SigningKeys, config.SecretKey, config.SigningAlg)
+	if err != nil {
+		logger.Log.Info(fmt.Sprintf("Error loading signing keys: %s", err.Error()))
+		return err
+	}
+	if config.SigningKeysFile == "" && config.SigningKeys == "" && config.SecretKey == "" {
+		logger.Log.Warn("No signing keys configured, using an ephemeral key: tokens will not survive a restart")
+	}
+	go reloadKeysOnSignal(keys)
+
+	rules, err := validation.NewRules(config)
+	if err != nil {
+		logger.Log.Info(fmt.Sprintf("Error initialize validation rules: %s", err.Error()))
+		return err
+	}
+
+	notify, err := notifier.NewNotifier(config)
+	if err != nil {
+		logger.Log.Info(fmt.Sprintf("Error initialize notifier: %s", err.Error()))
+		return err
+	}
+
 	pgStor, err := storage.NewPgStorage(config)
 	if err != nil {
 		logger.Log.Info(fmt.Sprintf("Error pg connection: %s", err.Error()))
@@ -41,13 +77,46 @@ func run() error {
 	ordersStorage := storage.NewOrdersStorage(pgStor)
 	usersStorage := storage.NewUsersStorage(pgStor)
 	withdrawalsStorage := storage.NewWithdrawalsStorage(pgStor)
+	tokensStorage := storage.NewTokensStorage(pgStor)
+	loginAttemptsStorage := storage.NewLoginAttemptsStorage(pgStor)
+	apiKeysStorage := storage.NewAPIKeysStorage(pgStor)
+
+	if config.GrantAdmin != "" {
+		defer pgStor.Close()
+		return grantAdmin(usersStorage, config.GrantAdmin)
+	}
+
+	authenticate := middleware.Authenticate(keys, tokensStorage, apiKeysStorage, config.CSRFProtection)
 
 	router := mux.NewRouter()
 
-	handler.NewBalancesHandler(router, config, balanceStorage)
-	handler.NewOrdersHandler(router, config, ordersStorage)
-	handler.NewUsersHandler(router, config, usersStorage)
-	handler.NewWithdrawalsHandler(router, config, withdrawalsStorage)
+	handler.NewBalancesHandler(router, config, balanceStorage, authenticate)
+	handler.NewOrdersHandler(router, config, ordersStorage, authenticate)
+	err = handler.NewUsersHandler(router, config, usersStorage, tokensStorage, loginAttemptsStorage, keys, rules)
+	if err != nil {
+		logger.Log.Info(fmt.Sprintf("Error initialize users handler: %s", err.Error()))
+		return err
+	}
+	handler.NewWithdrawalsHandler(router, config, withdrawalsStorage, authenticate)
+	handler.NewTokensHandler(router, config, tokensStorage, keys, authenticate)
+	handler.NewPasswordsHandler(router, config, usersStorage, tokensStorage, keys, notify, rules, authenticate)
+	handler.NewTwoFactorHandler(router, config, usersStorage, tokensStorage, loginAttemptsStorage, keys, authenticate)
+	handler.NewSessionsHandler(router, config, tokensStorage, authenticate)
+	handler.NewProfileHandler(router, config, usersStorage, authenticate)
+	handler.NewAccountHandler(router, config, usersStorage, authenticate)
+	handler.NewAPIKeysHandler(router, config, apiKeysStorage, authenticate)
+	handler.NewAdminHandler(router, config, usersStorage, balanceStorage, authenticate)
+	handler.NewJWKSHandler(router, keys)
+	if config.OIDCIssuer != "" {
+		provider := oidc.NewProvider(oidc.Config{
+			Issuer:       config.OIDCIssuer,
+			ClientID:     config.OIDCClientID,
+			ClientSecret: config.OIDCClientSecret,
+			RedirectURL:  config.OIDCRedirectURL,
+			Scopes:       strings.Fields(config.OIDCScopes),
+		})
+		handler.NewOIDCHandler(router, config, usersStorage, tokensStorage, keys, provider, rules)
+	}
 
 	defer pgStor.Close()
 
@@ -58,3 +127,40 @@ func run() error {
 	}
 	return nil
 }
+
+// grantAdmin makes the first administrator. It is a one-time step run by
+// hand, and it refuses to run once anybody holds the admin role: further
+// admins are appointed through the API.
+func grantAdmin(usersStorage *postgres.UsersStorage, userID string) error {
+	if _, err := uuid.Parse(userID); err != nil {
+		return fmt.Errorf("invalid user ID %q: %w", userID, err)
+	}
+
+	err := usersStorage.BootstrapRole(context.Background(), userID, auth.RoleAdmin)
+	if err != nil {
+		if errors.Is(err, dberrors.ErrRoleHeld) {
+			return errors.New("an admin already exists, appoint further admins through the API")
+		}
+		if errors.Is(err, dberrors.ErrNotFound) {
+			return fmt.Errorf("user %s is not registered", userID)
+		}
+		return err
+	}
+
+	logger.Log.Info(fmt.Sprintf("User %s granted the admin role", userID))
+
+	return nil
+}
+
+func reloadKeysOnSignal(keys *auth.KeySet) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	for range sighup {
+		if err := keys.Reload(); err != nil {
+			logger.Log.Info(fmt.Sprintf("Error reloading signing keys: %s", err.Error()))
+			continue
+		}
+		logger.Log.Info(fmt.Sprintf("Signing keys reloaded, active key %s", keys.ActiveID()))
+	}
+}
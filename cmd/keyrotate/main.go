@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/nu-kotov/gophermart/internal/auth"
+)
+
+func main() {
+	err := run()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	var path, retire, alg string
+	var list bool
+
+	flag.StringVar(&path, "k", "", "path to the JWT signing keys file")
+	flag.StringVar(&alg, "alg", auth.AlgHS256, "algorithm of the new key: HS256, RS256 or EdDSA")
+	flag.StringVar(&retire, "retire", "", "retire the key with this kid instead of rotating")
+	flag.BoolVar(&list, "list", false, "list keys without changing the file")
+	flag.Parse()
+
+	if path == "" {
+		return errors.New("keys file is required: -k <path>")
+	}
+
+	keys, err := auth.LoadKeySet(path, "", "", "")
+	if errors.Is(err, os.ErrNotExist) {
+		keys, err = auth.NewKeySet(), nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case list:
+		for _, key := range keys.Keys() {
+			status := "verify"
+			if key.Retired {
+				status = "retired"
+			}
+			if key.ID == keys.ActiveID() {
+				status = "active"
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\n", key.ID, key.Alg, key.CreatedAt.Format("2006-01-02T15:04:05Z07:00"), status)
+		}
+		return nil
+	case retire != "":
+		if err = keys.Retire(retire); err != nil {
+			return err
+		}
+		fmt.Printf("Key %s retired\n", retire)
+	default:
+		key, err := keys.Rotate(alg)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Key %s (%s) is now active\n", key.ID, key.Alg)
+	}
+
+	if err = keys.Save(path); err != nil {
+		return err
+	}
+	fmt.Println("Send SIGHUP to running gophermart instances to pick up the change")
+
+	return nil
+}
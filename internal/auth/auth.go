@@ -1,28 +1,98 @@
 package auth
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"slices"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
+const (
+	PurposeTwoFactor = "2fa"
+
+	RoleAdmin   = "admin"
+	RoleSupport = "support"
+)
+
+const (
+	ScopeOrdersRead       = "orders:read"
+	ScopeOrdersWrite      = "orders:write"
+	ScopeBalanceRead      = "balance:read"
+	ScopeWithdrawalsRead  = "withdrawals:read"
+	ScopeWithdrawalsWrite = "withdrawals:write"
+
+	APIKeyPrefix = "gm_"
+)
+
+var KnownRoles = []string{RoleAdmin, RoleSupport}
+
+var KnownScopes = []string{ScopeOrdersRead, ScopeOrdersWrite, ScopeBalanceRead, ScopeWithdrawalsRead, ScopeWithdrawalsWrite}
+
+var ErrTokenRevoked = errors.New("token is revoked")
+var ErrTokenPurpose = errors.New("token is not valid for this purpose")
+
 type Claims struct {
 	jwt.RegisteredClaims
-	UserID string
-	Login  string
+	UserID    string
+	Login     string
+	SessionID string
+	Roles     []string `json:",omitempty"`
+	Purpose   string   `json:",omitempty"`
+
+	// Set only for requests authenticated with an API key, never in a JWT.
+	APIKeyID string   `json:"-"`
+	Scopes   []string `json:"-"`
 }
 
-func BuildJWTString(userID string, login string, tokenExp time.Duration, secretKey string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(tokenExp)),
-		},
-		UserID: userID,
-		Login:  login,
-	})
+func (c *Claims) HasRole(role string) bool {
+	return slices.Contains(c.Roles, role)
+}
+
+// HasScope reports whether an API key was granted the scope. A user session
+// is not limited by scopes.
+func (c *Claims) HasScope(scope string) bool {
+	return c.APIKeyID == "" || slices.Contains(c.Scopes, scope)
+}
+
+type Revocations interface {
+	IsRevoked(context.Context, *Claims) (bool, error)
+}
 
-	tokenString, err := token.SignedString([]byte(secretKey))
+type claimsKey struct{}
+
+func BuildJWTString(userID string, login string, sessionID string, roles []string, tokenExp time.Duration, keys *KeySet) (string, error) {
+	return buildToken(Claims{UserID: userID, Login: login, SessionID: sessionID, Roles: roles}, tokenExp, keys)
+}
+
+// BuildPurposeToken issues a token that is accepted only by ParsePurposeToken
+// with the same purpose, e.g. the pre-auth token of a two-factor login.
+func BuildPurposeToken(userID string, login string, roles []string, purpose string, tokenExp time.Duration, keys *KeySet) (string, error) {
+	return buildToken(Claims{UserID: userID, Login: login, Roles: roles, Purpose: purpose}, tokenExp, keys)
+}
+
+func buildToken(claims Claims, tokenExp time.Duration, keys *KeySet) (string, error) {
+	kid, method, signKey, err := keys.signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        uuid.New().String(),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(tokenExp)),
+	}
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+
+	tokenString, err := token.SignedString(signKey)
 	if err != nil {
 		return "", err
 	}
@@ -30,22 +100,89 @@ func BuildJWTString(userID string, login string, tokenExp time.Duration, secretK
 	return tokenString, nil
 }
 
-func GetUserID(tokenString string, secretKey string) (string, error) {
+func ParseToken(ctx context.Context, tokenString string, keys *KeySet, revocations Revocations) (*Claims, error) {
+	return parseToken(ctx, tokenString, "", keys, revocations)
+}
+
+func ParsePurposeToken(ctx context.Context, tokenString string, purpose string, keys *KeySet, revocations Revocations) (*Claims, error) {
+	return parseToken(ctx, tokenString, purpose, keys, revocations)
+}
+
+func parseToken(ctx context.Context, tokenString string, purpose string, keys *KeySet, revocations Revocations) (*Claims, error) {
 	claims := &Claims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims,
 		func(t *jwt.Token) (any, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, errors.New("unexpected signing method")
-			}
-			return []byte(secretKey), nil
-		})
+			kid, _ := t.Header["kid"].(string)
+			return keys.verificationKey(kid, t.Method.Alg())
+		},
+		jwt.WithValidMethods([]string{AlgHS256, AlgRS256, AlgEdDSA}),
+	)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if !token.Valid {
-		return "", errors.New("token is not valid")
+		return nil, errors.New("token is not valid")
+	}
+
+	if claims.UserID == "" || claims.ID == "" {
+		return nil, errors.New("token has no user id or jti")
+	}
+
+	if claims.Purpose != purpose {
+		return nil, ErrTokenPurpose
+	}
+
+	if revocations != nil {
+		revoked, err := revocations.IsRevoked(ctx, claims)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
+	}
+
+	return claims, nil
+}
+
+func GetUserID(ctx context.Context, tokenString string, keys *KeySet, revocations Revocations) (string, error) {
+	claims, err := ParseToken(ctx, tokenString, keys, revocations)
+	if err != nil {
+		return "", err
 	}
 
 	return claims.UserID, nil
 }
+
+func NewAPIKey() (string, error) {
+	token, err := NewRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	return APIKeyPrefix + token, nil
+}
+
+func NewRandomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func ContextWithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok && claims != nil
+}
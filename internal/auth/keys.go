@@ -0,0 +1,364 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	DefaultKeyID = "default"
+
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+
+	rsaKeyBits = 2048
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+type Key struct {
+	ID         string    `json:"kid"`
+	Alg        string    `json:"alg,omitempty"`
+	Secret     string    `json:"secret,omitempty"`
+	PrivateKey string    `json:"private_key,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	Retired    bool      `json:"retired,omitempty"`
+}
+
+type keySetFile struct {
+	Active string `json:"active"`
+	Keys   []Key  `json:"keys"`
+}
+
+type keyMaterial struct {
+	method    jwt.SigningMethod
+	signKey   any
+	verifyKey any
+}
+
+type KeySet struct {
+	mu       sync.RWMutex
+	path     string
+	active   string
+	keys     map[string]Key
+	material map[string]keyMaterial
+	order    []string
+}
+
+func NewKeySet() *KeySet {
+	return &KeySet{
+		keys:     make(map[string]Key),
+		material: make(map[string]keyMaterial),
+	}
+}
+
+// LoadKeySet builds the signing keys from a keys file, a "kid:secret,..."
+// list whose first entry is the active key, or a single legacy secret, in
+// that order of preference. With nothing configured an ephemeral key of the
+// given algorithm is generated, so tokens do not survive a restart.
+func LoadKeySet(path string, spec string, secret string, alg string) (*KeySet, error) {
+	ks := NewKeySet()
+
+	switch {
+	case path != "":
+		ks.path = path
+		if err := ks.Reload(); err != nil {
+			return nil, err
+		}
+	case spec != "":
+		for _, pair := range strings.Split(spec, ",") {
+			kid, value, found := strings.Cut(strings.TrimSpace(pair), ":")
+			if !found || kid == "" || value == "" {
+				return nil, fmt.Errorf("invalid signing key %q: expected kid:secret", pair)
+			}
+			err := ks.add(Key{ID: kid, Alg: AlgHS256, Secret: base64.StdEncoding.EncodeToString([]byte(value))})
+			if err != nil {
+				return nil, err
+			}
+		}
+		ks.active = ks.order[0]
+	case secret != "":
+		err := ks.add(Key{ID: DefaultKeyID, Alg: AlgHS256, Secret: base64.StdEncoding.EncodeToString([]byte(secret))})
+		if err != nil {
+			return nil, err
+		}
+		ks.active = DefaultKeyID
+	default:
+		if _, err := ks.Rotate(alg); err != nil {
+			return nil, err
+		}
+	}
+
+	return ks, nil
+}
+
+func (ks *KeySet) Reload() error {
+	if ks.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(ks.path)
+	if err != nil {
+		return err
+	}
+
+	var file keySetFile
+	if err = json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+
+	loaded := NewKeySet()
+	for _, key := range file.Keys {
+		if err = loaded.add(key); err != nil {
+			return err
+		}
+	}
+
+	activeKey, ok := loaded.keys[file.Active]
+	if !ok || activeKey.Retired {
+		return fmt.Errorf("active signing key %q is missing or retired", file.Active)
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	ks.active = file.Active
+	ks.keys = loaded.keys
+	ks.material = loaded.material
+	ks.order = loaded.order
+
+	return nil
+}
+
+func (ks *KeySet) Save(path string) error {
+	ks.mu.RLock()
+	file := keySetFile{Active: ks.active}
+	for _, kid := range ks.order {
+		file.Keys = append(file.Keys, ks.keys[kid])
+	}
+	ks.mu.RUnlock()
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func (ks *KeySet) Rotate(alg string) (*Key, error) {
+	key, err := generateKey(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if err = ks.add(*key); err != nil {
+		return nil, err
+	}
+	ks.active = key.ID
+
+	return key, nil
+}
+
+func (ks *KeySet) Retire(kid string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	key, ok := ks.keys[kid]
+	if !ok {
+		return ErrUnknownKey
+	}
+	if kid == ks.active {
+		return errors.New("the active signing key cannot be retired")
+	}
+
+	key.Retired = true
+	ks.keys[kid] = key
+
+	return nil
+}
+
+func (ks *KeySet) Keys() []Key {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	keys := make([]Key, 0, len(ks.order))
+	for _, kid := range ks.order {
+		keys = append(keys, ks.keys[kid])
+	}
+
+	return keys
+}
+
+func (ks *KeySet) ActiveID() string {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	return ks.active
+}
+
+func (ks *KeySet) signingKey() (string, jwt.SigningMethod, any, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	material, ok := ks.material[ks.active]
+	if !ok {
+		return "", nil, nil, ErrUnknownKey
+	}
+
+	return ks.active, material.method, material.signKey, nil
+}
+
+func (ks *KeySet) verificationKey(kid string, alg string) (any, error) {
+	if kid == "" {
+		// Tokens issued before key IDs were introduced carry no kid header.
+		kid = DefaultKeyID
+	}
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	key, ok := ks.keys[kid]
+	if !ok || key.Retired {
+		return nil, ErrUnknownKey
+	}
+
+	material := ks.material[kid]
+	if material.method.Alg() != alg {
+		return nil, fmt.Errorf("unexpected signing method %s for key %s", alg, kid)
+	}
+
+	return material.verifyKey, nil
+}
+
+func (ks *KeySet) add(key Key) error {
+	if key.Alg == "" {
+		key.Alg = AlgHS256
+	}
+
+	material, err := parseKeyMaterial(key)
+	if err != nil {
+		return fmt.Errorf("signing key %s: %w", key.ID, err)
+	}
+
+	if _, exists := ks.keys[key.ID]; !exists {
+		ks.order = append(ks.order, key.ID)
+	}
+	ks.keys[key.ID] = key
+	ks.material[key.ID] = material
+
+	return nil
+}
+
+func generateKey(alg string) (*Key, error) {
+	if alg == "" {
+		alg = AlgHS256
+	}
+
+	kidBytes := make([]byte, 8)
+	if _, err := rand.Read(kidBytes); err != nil {
+		return nil, err
+	}
+
+	key := &Key{
+		ID:        base64.RawURLEncoding.EncodeToString(kidBytes),
+		Alg:       alg,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	var private crypto.PrivateKey
+	switch alg {
+	case AlgHS256:
+		secret := make([]byte, 64)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		key.Secret = base64.StdEncoding.EncodeToString(secret)
+		return key, nil
+	case AlgRS256:
+		rsaKey, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, err
+		}
+		private = rsaKey
+	case AlgEdDSA:
+		_, edKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		private = edKey
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	key.PrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
+	return key, nil
+}
+
+func parseKeyMaterial(key Key) (keyMaterial, error) {
+	if key.Alg == AlgHS256 {
+		secret, err := base64.StdEncoding.DecodeString(key.Secret)
+		if err != nil {
+			return keyMaterial{}, err
+		}
+		if len(secret) == 0 {
+			return keyMaterial{}, errors.New("empty secret")
+		}
+		return keyMaterial{method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}, nil
+	}
+
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return keyMaterial{}, errors.New("private key is not PEM encoded")
+	}
+
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return keyMaterial{}, err
+	}
+
+	switch key.Alg {
+	case AlgRS256:
+		rsaKey, ok := private.(*rsa.PrivateKey)
+		if !ok {
+			return keyMaterial{}, errors.New("RS256 key is not an RSA private key")
+		}
+		return keyMaterial{method: jwt.SigningMethodRS256, signKey: rsaKey, verifyKey: &rsaKey.PublicKey}, nil
+	case AlgEdDSA:
+		edKey, ok := private.(ed25519.PrivateKey)
+		if !ok {
+			return keyMaterial{}, errors.New("EdDSA key is not an Ed25519 private key")
+		}
+		return keyMaterial{method: jwt.SigningMethodEdDSA, signKey: edKey, verifyKey: edKey.Public()}, nil
+	}
+
+	return keyMaterial{}, fmt.Errorf("unsupported signing algorithm %q", key.Alg)
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"github.com/alexedwards/argon2id"
+)
+
+type PasswordHasher struct {
+	params *argon2id.Params
+}
+
+func NewPasswordHasher(memory uint32, iterations uint32, parallelism uint8) *PasswordHasher {
+	return &PasswordHasher{
+		params: &argon2id.Params{
+			Memory:      memory,
+			Iterations:  iterations,
+			Parallelism: parallelism,
+			SaltLength:  argon2id.DefaultParams.SaltLength,
+			KeyLength:   argon2id.DefaultParams.KeyLength,
+		},
+	}
+}
+
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	return argon2id.CreateHash(password, h.params)
+}
+
+// Compare reports whether the password matches the hash and whether the hash
+// was made with parameters other than the configured ones and should be
+// replaced now that the plain password is known.
+func (h *PasswordHasher) Compare(password string, hash string) (bool, bool, error) {
+	match, params, err := argon2id.CheckHash(password, hash)
+	if err != nil || !match {
+		return false, false, err
+	}
+
+	rehash := params.Memory != h.params.Memory ||
+		params.Iterations != h.params.Iterations ||
+		params.Parallelism != h.params.Parallelism ||
+		params.SaltLength != h.params.SaltLength ||
+		params.KeyLength != h.params.KeyLength
+
+	return true, rehash, nil
+}
@@ -1,33 +1,103 @@
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/caarlos0/env"
 )
 
 type Config struct {
-	RunAddr            string `env:"RUN_ADDRESS"`
-	DatabaseConnection string `env:"DATABASE_URI"`
-	AccrualAddr        string `env:"ACCRUAL_SYSTEM_ADDRESS"`
-	SecretKey          string `env:"SECRET_KEY"`
-	TokenExp           time.Duration
-	TickerPeriod       time.Duration
-	WorkersNum         int
+	RunAddr               string        `env:"RUN_ADDRESS"`
+	DatabaseConnection    string        `env:"DATABASE_URI"`
+	AccrualAddr           string        `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	SecretKey             string        `env:"SECRET_KEY"`
+	SigningKeys           string        `env:"SIGNING_KEYS"`
+	SigningKeysFile       string        `env:"SIGNING_KEYS_FILE"`
+	SigningAlg            string        `env:"SIGNING_ALG"`
+	TokenExp              time.Duration `env:"TOKEN_EXP"`
+	RefreshTokenExp       time.Duration `env:"REFRESH_TOKEN_EXP"`
+	LoginPattern          string        `env:"LOGIN_PATTERN"`
+	PasswordMinLength     int           `env:"PASSWORD_MIN_LENGTH"`
+	PasswordMinClasses    int           `env:"PASSWORD_MIN_CLASSES"`
+	Argon2Memory          uint          `env:"ARGON2_MEMORY"`
+	Argon2Iterations      uint          `env:"ARGON2_ITERATIONS"`
+	Argon2Parallelism     uint          `env:"ARGON2_PARALLELISM"`
+	LoginMaxAttempts      int           `env:"LOGIN_MAX_ATTEMPTS"`
+	LoginMaxAttemptsPerIP int           `env:"LOGIN_MAX_ATTEMPTS_PER_IP"`
+	LoginAttemptsWindow   time.Duration `env:"LOGIN_ATTEMPTS_WINDOW"`
+	LoginLockout          time.Duration `env:"LOGIN_LOCKOUT"`
+	LoginLockoutMax       time.Duration `env:"LOGIN_LOCKOUT_MAX"`
+	PasswordResetExp      time.Duration `env:"PASSWORD_RESET_EXP"`
+	Notifier              string        `env:"NOTIFIER"`
+	NotifierFile          string        `env:"NOTIFIER_FILE"`
+	TOTPIssuer            string        `env:"TOTP_ISSUER"`
+	PreAuthTokenExp       time.Duration `env:"PRE_AUTH_TOKEN_EXP"`
+	TwoFactorMaxAttempts  int           `env:"TWO_FACTOR_MAX_ATTEMPTS"`
+	ReauthMaxAge          time.Duration `env:"REAUTH_MAX_AGE"`
+	OIDCIssuer            string        `env:"OIDC_ISSUER"`
+	OIDCClientID          string        `env:"OIDC_CLIENT_ID"`
+	OIDCClientSecret      string        `env:"OIDC_CLIENT_SECRET"`
+	OIDCRedirectURL       string        `env:"OIDC_REDIRECT_URL"`
+	OIDCScopes            string        `env:"OIDC_SCOPES"`
+	OIDCSuccessURL        string        `env:"OIDC_SUCCESS_URL"`
+	CookieSecure          bool          `env:"COOKIE_SECURE"`
+	CookieSameSite        string        `env:"COOKIE_SAMESITE"`
+	CookieDomain          string        `env:"COOKIE_DOMAIN"`
+	CookiePath            string        `env:"COOKIE_PATH"`
+	CSRFProtection        bool          `env:"CSRF_PROTECTION"`
+	OrdersBatchMaxSize    int           `env:"ORDERS_BATCH_MAX_SIZE"`
+	OrdersPageSize        int           `env:"ORDERS_PAGE_SIZE"`
+	OrdersPageMaxSize     int           `env:"ORDERS_PAGE_MAX_SIZE"`
+	OrdersLegacyList      bool          `env:"ORDERS_LEGACY_LIST"`
+	GrantAdmin            string
+	TickerPeriod          time.Duration
+	WorkersNum            int
 }
 
 func NewConfig() (*Config, error) {
 	var config Config
 
-	config.SecretKey = "supersecretkey"
-	config.TokenExp = time.Hour * 72
+	config.TokenExp = time.Minute * 15
+	config.RefreshTokenExp = time.Hour * 24 * 30
+	config.LoginPattern = `^[A-Za-z0-9._@-]{3,64}$`
+	config.PasswordMinLength = 8
+	config.PasswordMinClasses = 2
+	config.Argon2Memory = 64 * 1024
+	config.Argon2Iterations = 1
+	config.Argon2Parallelism = 2
+	config.LoginMaxAttempts = 5
+	config.LoginMaxAttemptsPerIP = 50
+	config.LoginAttemptsWindow = time.Minute * 15
+	config.LoginLockout = time.Minute
+	config.LoginLockoutMax = time.Hour
+	config.PasswordResetExp = time.Hour
+	config.Notifier = "log"
+	config.TOTPIssuer = "Gophermart"
+	config.PreAuthTokenExp = time.Minute * 5
+	config.TwoFactorMaxAttempts = 5
+	config.ReauthMaxAge = time.Minute * 5
+	config.OIDCScopes = "openid profile email"
+	config.CookieSameSite = "Lax"
+	config.CookiePath = "/"
+	config.CSRFProtection = true
+	config.OrdersBatchMaxSize = 10000
+	config.OrdersPageSize = 100
+	config.OrdersPageMaxSize = 1000
 	config.TickerPeriod = time.Second * 1
 	config.WorkersNum = 500
 
 	flag.StringVar(&config.RunAddr, "a", "localhost:8181", "address and port to run server")
 	flag.StringVar(&config.DatabaseConnection, "d", "", "Database connection string")
 	flag.StringVar(&config.AccrualAddr, "r", "http://localhost:8888", "default schema, host and port in compressed URL")
+	flag.StringVar(&config.SigningKeysFile, "k", "", "path to the JWT signing keys file")
+	flag.StringVar(&config.SigningAlg, "alg", "HS256", "JWT signing algorithm for a generated key: HS256, RS256 or EdDSA")
+	flag.StringVar(&config.GrantAdmin, "grant-admin", "", "grant the admin role to the user with this ID and exit, refused once an admin exists")
 
 	flag.Parse()
 	err := env.Parse(&config)
@@ -35,5 +105,42 @@ func NewConfig() (*Config, error) {
 		return nil, err
 	}
 
+	if config.Argon2Memory == 0 || config.Argon2Iterations == 0 || config.Argon2Parallelism == 0 || config.Argon2Parallelism > math.MaxUint8 {
+		return nil, errors.New("invalid argon2id parameters")
+	}
+
+	if config.OrdersBatchMaxSize <= 0 {
+		return nil, errors.New("ORDERS_BATCH_MAX_SIZE must be positive")
+	}
+
+	if config.OrdersPageSize <= 0 || config.OrdersPageSize > config.OrdersPageMaxSize {
+		return nil, errors.New("ORDERS_PAGE_SIZE must be positive and not exceed ORDERS_PAGE_MAX_SIZE")
+	}
+
+	switch strings.ToLower(config.CookieSameSite) {
+	case "lax", "strict":
+	case "none":
+		if !config.CookieSecure {
+			return nil, errors.New("COOKIE_SAMESITE=None requires COOKIE_SECURE=true")
+		}
+	default:
+		return nil, fmt.Errorf("invalid COOKIE_SAMESITE %q: expected Lax, Strict or None", config.CookieSameSite)
+	}
+
+	if config.OIDCIssuer != "" && (config.OIDCClientID == "" || config.OIDCRedirectURL == "") {
+		return nil, errors.New("OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required with OIDC_ISSUER")
+	}
+
 	return &config, nil
 }
+
+func (c *Config) CookieSameSiteMode() http.SameSite {
+	switch strings.ToLower(c.CookieSameSite) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+
+	return http.SameSiteLaxMode
+}
@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nu-kotov/gophermart/internal/auth"
+	"github.com/nu-kotov/gophermart/internal/config"
+	"github.com/nu-kotov/gophermart/internal/logger"
+	"github.com/nu-kotov/gophermart/internal/middleware"
+	"github.com/nu-kotov/gophermart/internal/models"
+	"github.com/nu-kotov/gophermart/internal/storage/dberrors"
+)
+
+type AccountStorage interface {
+	SelectUserByID(context.Context, string) (*models.UserData, error)
+	SelectUserExport(context.Context, string) (*models.UserExport, error)
+	DeleteUser(context.Context, string) error
+	HasLinkedIdentity(context.Context, string) (bool, error)
+	SelectSessionCreatedAt(context.Context, string, string) (time.Time, error)
+}
+
+// ReauthStorage is what reauthenticated needs to confirm the user.
+type ReauthStorage interface {
+	SelectUserByID(context.Context, string) (*models.UserData, error)
+	HasLinkedIdentity(context.Context, string) (bool, error)
+	SelectSessionCreatedAt(context.Context, string, string) (time.Time, error)
+}
+
+type AccountHandler struct {
+	Config  *config.Config
+	Storage AccountStorage
+	Hasher  *auth.PasswordHasher
+}
+
+func NewAccountHandler(router *mux.Router, cfg *config.Config, storage AccountStorage, authenticate middleware.Authenticator) {
+
+	handler := &AccountHandler{
+		Config:  cfg,
+		Storage: storage,
+		Hasher:  auth.NewPasswordHasher(uint32(cfg.Argon2Memory), uint32(cfg.Argon2Iterations), uint8(cfg.Argon2Parallelism)),
+	}
+
+	middlewareStack := middleware.Chain(
+		middleware.RequestLogger,
+		authenticate(),
+	)
+
+	router.HandleFunc(`/api/user/export`, middlewareStack(handler.ExportUserData())).Methods("GET")
+	router.HandleFunc(`/api/user`, middlewareStack(handler.DeleteUser())).Methods("DELETE")
+}
+
+func (handler *AccountHandler) ExportUserData() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
+			logger.Log.Info("User unauthorized")
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		data, err := handler.Storage.SelectUserExport(req.Context(), claims.UserID)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Export error", http.StatusInternalServerError)
+			return
+		}
+
+		resp, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		res.Header().Set("Content-Disposition", `attachment; filename="gophermart-export.json"`)
+		res.WriteHeader(http.StatusOK)
+		_, err = res.Write(resp)
+
+		if err != nil {
+			logger.Log.Info(err.Error())
+		}
+	}
+}
+
+func (handler *AccountHandler) DeleteUser() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
+			logger.Log.Info("User unauthorized")
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Invalid body", http.StatusBadRequest)
+			return
+		}
+
+		var jsonBody models.DeleteAccountRequest
+		if err = json.Unmarshal(body, &jsonBody); err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Deletion cannot be undone, so a stolen access token is not enough.
+		confirmed, err := reauthenticated(req.Context(), handler.Config, handler.Storage, handler.Hasher, claims, jsonBody.Password)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Delete user error", http.StatusInternalServerError)
+			return
+		}
+		if !confirmed {
+			http.Error(res, "Incorrect password", http.StatusForbidden)
+			return
+		}
+
+		err = handler.Storage.DeleteUser(req.Context(), claims.UserID)
+		if err != nil {
+			if errors.Is(err, dberrors.ErrNotFound) {
+				http.Error(res, "User not found", http.StatusNotFound)
+				return
+			}
+			logger.Log.Info(err.Error())
+			http.Error(res, "Delete user error", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Log.Info(fmt.Sprintf("User %s deleted their account", claims.UserID))
+
+		clearTokenCookies(res, handler.Config)
+		res.Header().Set("Content-Type", "text/plain")
+		res.WriteHeader(http.StatusOK)
+		io.WriteString(res, "User deleted")
+	}
+}
+
+// reauthenticated confirms a sensitive action with the account password.
+// Accounts with a linked identity may not know their password, so for them a
+// session started within ReauthMaxAge, i.e. a fresh login through the
+// provider or with 2FA, is accepted instead.
+func reauthenticated(ctx context.Context, cfg *config.Config, storage ReauthStorage, hasher *auth.PasswordHasher, claims *auth.Claims, password string) (bool, error) {
+	if password != "" {
+		userData, err := storage.SelectUserByID(ctx, claims.UserID)
+		if err != nil {
+			return false, err
+		}
+
+		match, _, err := hasher.Compare(password, userData.Password)
+		if err != nil {
+			return false, err
+		}
+		return match, nil
+	}
+
+	// API keys have no session and can never be used as a re-login.
+	if claims.SessionID == "" {
+		return false, nil
+	}
+
+	linked, err := storage.HasLinkedIdentity(ctx, claims.UserID)
+	if err != nil || !linked {
+		return false, err
+	}
+
+	createdAt, err := storage.SelectSessionCreatedAt(ctx, claims.UserID, claims.SessionID)
+	if errors.Is(err, dberrors.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return time.Since(createdAt) <= cfg.ReauthMaxAge, nil
+}
@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/nu-kotov/gophermart/internal/auth"
+	"github.com/nu-kotov/gophermart/internal/config"
+	"github.com/nu-kotov/gophermart/internal/logger"
+	"github.com/nu-kotov/gophermart/internal/middleware"
+	"github.com/nu-kotov/gophermart/internal/models"
+	"github.com/nu-kotov/gophermart/internal/storage/dberrors"
+)
+
+type AdminStorage interface {
+	UpdateUserRoles(context.Context, string, []string) error
+}
+
+type BalanceAdjustmentsStorage interface {
+	AdjustBalance(context.Context, *models.LedgerEntry) error
+}
+
+type AdminHandler struct {
+	Config   *config.Config
+	Storage  AdminStorage
+	Balances BalanceAdjustmentsStorage
+}
+
+func NewAdminHandler(router *mux.Router, cfg *config.Config, storage AdminStorage, balances BalanceAdjustmentsStorage, authenticate middleware.Authenticator) {
+
+	handler := &AdminHandler{
+		Config:   cfg,
+		Storage:  storage,
+		Balances: balances,
+	}
+
+	adminMiddlewareStack := middleware.Chain(
+		middleware.RequestLogger,
+		authenticate(),
+		middleware.RequireRoles(auth.RoleAdmin),
+	)
+
+	router.HandleFunc(`/api/admin/users/{id}/roles`, adminMiddlewareStack(handler.SetUserRoles())).Methods("PUT")
+	router.HandleFunc(`/api/admin/users/{id}/balance/adjustments`, adminMiddlewareStack(handler.AdjustBalance())).Methods("POST")
+}
+
+func (handler *AdminHandler) SetUserRoles() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
+			logger.Log.Info("User unauthorized")
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		userID := mux.Vars(req)["id"]
+		if _, err := uuid.Parse(userID); err != nil {
+			http.Error(res, "User not found", http.StatusNotFound)
+			return
+		}
+
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Invalid body", http.StatusBadRequest)
+			return
+		}
+
+		var jsonBody models.UserRolesRequest
+		if err = json.Unmarshal(body, &jsonBody); err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		roles := []string{}
+		for _, role := range jsonBody.Roles {
+			if !slices.Contains(auth.KnownRoles, role) {
+				http.Error(res, fmt.Sprintf("Unknown role %q", role), http.StatusBadRequest)
+				return
+			}
+			if !slices.Contains(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+
+		if userID == claims.UserID && !slices.Contains(roles, auth.RoleAdmin) {
+			http.Error(res, "Admins cannot revoke their own admin role", http.StatusConflict)
+			return
+		}
+
+		err = handler.Storage.UpdateUserRoles(req.Context(), userID, roles)
+		if err != nil {
+			if errors.Is(err, dberrors.ErrNotFound) {
+				http.Error(res, "User not found", http.StatusNotFound)
+				return
+			}
+			logger.Log.Info(err.Error())
+			http.Error(res, "Set roles error", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Log.Info(fmt.Sprintf("Admin %s set roles %v for user %s", claims.UserID, roles, userID))
+
+		res.Header().Set("Content-Type", "text/plain")
+		res.WriteHeader(http.StatusOK)
+		io.WriteString(res, "Roles updated")
+	}
+}
+
+// AdjustBalance books a manual correction to the ledger. The reason is kept
+// with the entry together with the admin who made it.
+func (handler *AdminHandler) AdjustBalance() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
+			logger.Log.Info("User unauthorized")
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		userID := mux.Vars(req)["id"]
+		if _, err := uuid.Parse(userID); err != nil {
+			http.Error(res, "User not found", http.StatusNotFound)
+			return
+		}
+
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Invalid body", http.StatusBadRequest)
+			return
+		}
+
+		var jsonBody models.BalanceAdjustmentRequest
+		if err = json.Unmarshal(body, &jsonBody); err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		jsonBody.Reason = strings.TrimSpace(jsonBody.Reason)
+		if jsonBody.Amount == 0 || jsonBody.Reason == "" {
+			http.Error(res, "Amount and reason are required", http.StatusBadRequest)
+			return
+		}
+
+		err = handler.Balances.AdjustBalance(req.Context(), &models.LedgerEntry{
+			UserID:    userID,
+			Amount:    jsonBody.Amount,
+			Reason:    jsonBody.Reason,
+			CreatedBy: claims.UserID,
+		})
+		if err != nil {
+			if errors.Is(err, dberrors.ErrNotFound) {
+				http.Error(res, "User not found", http.StatusNotFound)
+				return
+			}
+			if errors.Is(err, dberrors.ErrInsufficientFunds) {
+				http.Error(res, "Adjustment would overdraw the balance", http.StatusConflict)
+				return
+			}
+			logger.Log.Info(err.Error())
+			http.Error(res, "Adjust balance error", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Log.Info(fmt.Sprintf("Admin %s adjusted the balance of user %s by %s: %s", claims.UserID, userID, jsonBody.Amount, jsonBody.Reason))
+
+		res.Header().Set("Content-Type", "text/plain")
+		res.WriteHeader(http.StatusCreated)
+		io.WriteString(res, "Balance adjusted")
+	}
+}
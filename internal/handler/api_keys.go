@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/nu-kotov/gophermart/internal/auth"
+	"github.com/nu-kotov/gophermart/internal/config"
+	"github.com/nu-kotov/gophermart/internal/logger"
+	"github.com/nu-kotov/gophermart/internal/middleware"
+	"github.com/nu-kotov/gophermart/internal/models"
+	"github.com/nu-kotov/gophermart/internal/storage/dberrors"
+)
+
+const apiKeyPrefixLen = 8
+
+type APIKeysStorage interface {
+	InsertAPIKey(context.Context, *models.APIKey) error
+	SelectUserAPIKeys(context.Context, string) ([]models.APIKey, error)
+	RevokeAPIKey(context.Context, string, string) error
+}
+
+type APIKeysHandler struct {
+	Config  *config.Config
+	Storage APIKeysStorage
+}
+
+func NewAPIKeysHandler(router *mux.Router, cfg *config.Config, storage APIKeysStorage, authenticate middleware.Authenticator) {
+
+	handler := &APIKeysHandler{
+		Config:  cfg,
+		Storage: storage,
+	}
+
+	// Keys are managed from a user session only, an API key cannot mint or
+	// revoke other keys.
+	middlewareStack := middleware.Chain(
+		middleware.RequestLogger,
+		authenticate(),
+	)
+
+	router.HandleFunc(`/api/user/api-keys`, middlewareStack(handler.CreateAPIKey())).Methods("POST")
+	router.HandleFunc(`/api/user/api-keys`, middlewareStack(handler.GetUserAPIKeys())).Methods("GET")
+	router.HandleFunc(`/api/user/api-keys/{id}`, middlewareStack(handler.RevokeAPIKey())).Methods("DELETE")
+}
+
+func (handler *APIKeysHandler) CreateAPIKey() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
+			logger.Log.Info("User unauthorized")
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Invalid body", http.StatusBadRequest)
+			return
+		}
+
+		var jsonBody models.CreateAPIKeyRequest
+		if err = json.Unmarshal(body, &jsonBody); err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		name := strings.TrimSpace(jsonBody.Name)
+		if name == "" || len(name) > 100 {
+			http.Error(res, "Name must be 1 to 100 characters", http.StatusBadRequest)
+			return
+		}
+		if len(jsonBody.Scopes) == 0 {
+			http.Error(res, "At least one scope is required", http.StatusBadRequest)
+			return
+		}
+
+		scopes := []string{}
+		for _, scope := range jsonBody.Scopes {
+			if !slices.Contains(auth.KnownScopes, scope) {
+				http.Error(res, fmt.Sprintf("Unknown scope %q", scope), http.StatusBadRequest)
+				return
+			}
+			if !slices.Contains(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+
+		if jsonBody.ExpiresIn < 0 {
+			http.Error(res, "expires_in must not be negative", http.StatusBadRequest)
+			return
+		}
+
+		apiKey, err := auth.NewAPIKey()
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Create API key error", http.StatusInternalServerError)
+			return
+		}
+
+		key := models.APIKey{
+			KeyID:     uuid.New().String(),
+			UserID:    claims.UserID,
+			Name:      name,
+			Prefix:    apiKey[:len(auth.APIKeyPrefix)+apiKeyPrefixLen],
+			KeyHash:   auth.HashToken(apiKey),
+			Scopes:    scopes,
+			CreatedAt: time.Now(),
+		}
+		if jsonBody.ExpiresIn > 0 {
+			expiresAt := key.CreatedAt.Add(time.Duration(jsonBody.ExpiresIn) * time.Second)
+			key.ExpiresAt = &expiresAt
+		}
+
+		if err = handler.Storage.InsertAPIKey(req.Context(), &key); err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Create API key error", http.StatusInternalServerError)
+			return
+		}
+
+		// The key itself is returned only once, the storage keeps its hash.
+		resp, err := json.Marshal(models.CreateAPIKeyResponse{APIKey: key, Key: apiKey})
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		res.WriteHeader(http.StatusCreated)
+		_, err = res.Write(resp)
+
+		if err != nil {
+			logger.Log.Info(err.Error())
+		}
+	}
+}
+
+func (handler *APIKeysHandler) GetUserAPIKeys() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
+			logger.Log.Info("User unauthorized")
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		data, err := handler.Storage.SelectUserAPIKeys(req.Context(), claims.UserID)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Get API keys error", http.StatusInternalServerError)
+			return
+		}
+
+		if len(data) == 0 {
+			res.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		resp, err := json.Marshal(data)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		res.WriteHeader(http.StatusOK)
+		_, err = res.Write(resp)
+
+		if err != nil {
+			logger.Log.Info(err.Error())
+		}
+	}
+}
+
+func (handler *APIKeysHandler) RevokeAPIKey() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
+			logger.Log.Info("User unauthorized")
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		keyID := mux.Vars(req)["id"]
+		if _, err := uuid.Parse(keyID); err != nil {
+			http.Error(res, "API key not found", http.StatusNotFound)
+			return
+		}
+
+		err := handler.Storage.RevokeAPIKey(req.Context(), claims.UserID, keyID)
+		if err != nil {
+			if errors.Is(err, dberrors.ErrNotFound) {
+				http.Error(res, "API key not found", http.StatusNotFound)
+				return
+			}
+			logger.Log.Info(err.Error())
+			http.Error(res, "Revoke API key error", http.StatusInternalServerError)
+			return
+		}
+
+		res.Header().Set("Content-Type", "text/plain")
+		res.WriteHeader(http.StatusOK)
+		io.WriteString(res, "API key revoked")
+	}
+}
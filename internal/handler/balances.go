@@ -21,7 +21,7 @@ import (
 
 type BalancesStorage interface {
 	SelectUserBalance(context.Context, string) (*models.UserBalance, error)
-	UpdateUserBalance(context.Context, *models.UserBalance, *models.Withdraw) error
+	Withdraw(context.Context, *models.Withdraw) error
 }
 
 type BalancesHandler struct {
@@ -29,37 +29,36 @@ type BalancesHandler struct {
 	Storage BalancesStorage
 }
 
-func NewBalancesHandler(router *mux.Router, cfg *config.Config, storage BalancesStorage) {
+func NewBalancesHandler(router *mux.Router, cfg *config.Config, storage BalancesStorage, authenticate middleware.Authenticator) {
 
 	handler := &BalancesHandler{
 		Config:  cfg,
 		Storage: storage,
 	}
 
-	middlewareStack := middleware.Chain(
+	readMiddlewareStack := middleware.Chain(
 		middleware.RequestLogger,
+		authenticate(auth.ScopeBalanceRead),
 	)
 
-	router.HandleFunc(`/api/user/balance`, middlewareStack(handler.GetUserBalance())).Methods("GET")
-	router.HandleFunc(`/api/user/balance/withdraw`, middlewareStack(handler.WithdrawPoints())).Methods("POST")
+	withdrawMiddlewareStack := middleware.Chain(
+		middleware.RequestLogger,
+		authenticate(auth.ScopeWithdrawalsWrite),
+	)
+
+	router.HandleFunc(`/api/user/balance`, readMiddlewareStack(handler.GetUserBalance())).Methods("GET")
+	router.HandleFunc(`/api/user/balance/withdraw`, withdrawMiddlewareStack(handler.WithdrawPoints())).Methods("POST")
 }
 
 func (handler *BalancesHandler) GetUserBalance() http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
-		token, err := req.Cookie("token")
-
-		if err != nil {
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
 			logger.Log.Info("User unauthorized")
-			res.WriteHeader(http.StatusUnauthorized)
-			return
-		}
-
-		userID, err := auth.GetUserID(token.Value, handler.Config.SecretKey)
-		if err != nil {
-			logger.Log.Info(err.Error())
-			http.Error(res, err.Error(), http.StatusBadRequest)
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
 			return
 		}
+		userID := claims.UserID
 
 		data, err := handler.Storage.SelectUserBalance(req.Context(), userID)
 		if err != nil {
@@ -105,19 +104,13 @@ func (handler *BalancesHandler) GetUserBalance() http.HandlerFunc {
 
 func (handler *BalancesHandler) WithdrawPoints() http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
-		token, err := req.Cookie("token")
-
-		if err != nil {
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
 			logger.Log.Info("User unauthorized")
-			res.WriteHeader(http.StatusUnauthorized)
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
 			return
 		}
-
-		userID, err := auth.GetUserID(token.Value, handler.Config.SecretKey)
-		if err != nil {
-			logger.Log.Info(err.Error())
-			http.Error(res, err.Error(), http.StatusBadRequest)
-		}
+		userID := claims.UserID
 
 		body, err := io.ReadAll(req.Body)
 		if err != nil {
@@ -146,22 +139,10 @@ func (handler *BalancesHandler) WithdrawPoints() http.HandlerFunc {
 			return
 		}
 
-		data, err := handler.Storage.SelectUserBalance(req.Context(), userID)
-		if err != nil {
-			if errors.Is(err, dberrors.ErrUserNoBalance) {
-				res.WriteHeader(http.StatusOK)
-				return
-			}
-			http.Error(res, err.Error(), http.StatusBadRequest)
-			return
-		}
-
-		if data.Current < jsonBody.Sum {
-			http.Error(res, "Insufficient funds", http.StatusPaymentRequired)
+		if jsonBody.Sum <= 0 {
+			http.Error(res, "Invalid sum", http.StatusBadRequest)
 			return
 		}
-		data.Current = data.Current - jsonBody.Sum
-		data.Withdrawn = data.Withdrawn + jsonBody.Sum
 
 		withdraw := models.Withdraw{
 			Number:      intNumber,
@@ -169,8 +150,16 @@ func (handler *BalancesHandler) WithdrawPoints() http.HandlerFunc {
 			Sum:         jsonBody.Sum,
 			WithdrawnAt: time.Now(),
 		}
-		err = handler.Storage.UpdateUserBalance(req.Context(), data, &withdraw)
+		err = handler.Storage.Withdraw(req.Context(), &withdraw)
 		if err != nil {
+			if errors.Is(err, dberrors.ErrInsufficientFunds) {
+				http.Error(res, "Insufficient funds", http.StatusPaymentRequired)
+				return
+			}
+			if errors.Is(err, dberrors.ErrOrderDuplicate) {
+				http.Error(res, "Order has already been used for a withdrawal", http.StatusConflict)
+				return
+			}
 			logger.Log.Info(err.Error())
 			http.Error(res, "User update error", http.StatusInternalServerError)
 			return
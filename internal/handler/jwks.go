@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/nu-kotov/gophermart/internal/auth"
+	"github.com/nu-kotov/gophermart/internal/logger"
+	"github.com/nu-kotov/gophermart/internal/middleware"
+)
+
+type JWKSHandler struct {
+	Keys *auth.KeySet
+}
+
+func NewJWKSHandler(router *mux.Router, keys *auth.KeySet) {
+
+	handler := &JWKSHandler{
+		Keys: keys,
+	}
+
+	middlewareStack := middleware.Chain(
+		middleware.RequestLogger,
+	)
+
+	router.HandleFunc(`/.well-known/jwks.json`, middlewareStack(handler.GetJWKS())).Methods("GET")
+}
+
+func (handler *JWKSHandler) GetJWKS() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		resp, err := json.Marshal(handler.Keys.JWKS())
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		res.Header().Set("Cache-Control", "public, max-age=300")
+		res.WriteHeader(http.StatusOK)
+		_, err = res.Write(resp)
+
+		if err != nil {
+			logger.Log.Info(err.Error())
+		}
+	}
+}
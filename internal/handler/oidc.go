@@ -0,0 +1,242 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/nu-kotov/gophermart/internal/auth"
+	"github.com/nu-kotov/gophermart/internal/config"
+	"github.com/nu-kotov/gophermart/internal/logger"
+	"github.com/nu-kotov/gophermart/internal/middleware"
+	"github.com/nu-kotov/gophermart/internal/models"
+	"github.com/nu-kotov/gophermart/internal/oidc"
+	"github.com/nu-kotov/gophermart/internal/storage/dberrors"
+	"github.com/nu-kotov/gophermart/internal/validation"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcPath        = "/api/user/oidc"
+	oidcStateExp    = 10 * time.Minute
+)
+
+type OIDCStorage interface {
+	SelectUserByIdentity(context.Context, string, string) (*models.UserData, error)
+	InsertUserWithIdentity(context.Context, *models.UserData, *models.UserIdentity) error
+}
+
+type OIDCHandler struct {
+	Config   *config.Config
+	Storage  OIDCStorage
+	Tokens   TokensStorage
+	Keys     *auth.KeySet
+	Provider *oidc.Provider
+	Rules    *validation.Rules
+	Hasher   *auth.PasswordHasher
+}
+
+func NewOIDCHandler(router *mux.Router, cfg *config.Config, storage OIDCStorage, tokens TokensStorage, keys *auth.KeySet, provider *oidc.Provider, rules *validation.Rules) {
+
+	handler := &OIDCHandler{
+		Config:   cfg,
+		Storage:  storage,
+		Tokens:   tokens,
+		Keys:     keys,
+		Provider: provider,
+		Rules:    rules,
+		Hasher:   auth.NewPasswordHasher(uint32(cfg.Argon2Memory), uint32(cfg.Argon2Iterations), uint8(cfg.Argon2Parallelism)),
+	}
+
+	middlewareStack := middleware.Chain(
+		middleware.RequestLogger,
+	)
+
+	router.HandleFunc(oidcPath+`/login`, middlewareStack(handler.StartLogin())).Methods("GET")
+	router.HandleFunc(oidcPath+`/callback`, middlewareStack(handler.Callback())).Methods("GET")
+}
+
+func (handler *OIDCHandler) StartLogin() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		var state models.OIDCState
+		for _, value := range []*string{&state.State, &state.Nonce, &state.Verifier} {
+			random, err := oidc.NewVerifier()
+			if err != nil {
+				logger.Log.Info(err.Error())
+				http.Error(res, "OIDC login error", http.StatusInternalServerError)
+				return
+			}
+			*value = random
+		}
+
+		redirectURL, err := handler.Provider.AuthCodeURL(req.Context(), state.State, state.Nonce, state.Verifier)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Identity provider unavailable", http.StatusBadGateway)
+			return
+		}
+
+		stateJSON, err := json.Marshal(state)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Lax is required: the callback is a cross-site navigation from the
+		// identity provider.
+		cookie := newCookie(handler.Config, oidcStateCookie, base64.RawURLEncoding.EncodeToString(stateJSON), oidcPath, oidcStateExp, true)
+		cookie.SameSite = http.SameSiteLaxMode
+		cookie.Secure = handler.Config.CookieSecure || req.TLS != nil
+		http.SetCookie(res, cookie)
+
+		http.Redirect(res, req, redirectURL, http.StatusFound)
+	}
+}
+
+func (handler *OIDCHandler) Callback() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		state, err := oidcStateFromRequest(req)
+		http.SetCookie(res, newCookie(handler.Config, oidcStateCookie, "", oidcPath, -1, true))
+		if err != nil {
+			logger.Log.Info("OIDC state rejected: " + err.Error())
+			http.Error(res, "Invalid OIDC state", http.StatusBadRequest)
+			return
+		}
+
+		query := req.URL.Query()
+		if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state.State)) != 1 {
+			logger.Log.Info("OIDC state mismatch")
+			http.Error(res, "Invalid OIDC state", http.StatusBadRequest)
+			return
+		}
+		if errCode := query.Get("error"); errCode != "" {
+			logger.Log.Info(fmt.Sprintf("OIDC login failed: %s %s", errCode, query.Get("error_description")))
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if query.Get("code") == "" {
+			http.Error(res, "Authorization code is required", http.StatusBadRequest)
+			return
+		}
+
+		idToken, err := handler.Provider.Exchange(req.Context(), query.Get("code"), state.Verifier, state.Nonce)
+		if err != nil {
+			logger.Log.Info("OIDC exchange failed: " + err.Error())
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		userData, err := handler.Storage.SelectUserByIdentity(req.Context(), handler.Provider.Issuer(), idToken.Subject)
+		if errors.Is(err, dberrors.ErrNotFound) {
+			userData, err = handler.createUser(req.Context(), idToken)
+		}
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "OIDC login error", http.StatusInternalServerError)
+			return
+		}
+
+		if userData.TwoFactorEnabled {
+			requireTwoFactor(res, handler.Config, handler.Keys, userData.UserID, userData.Login, userData.Roles)
+			return
+		}
+
+		err = issueTokens(res, req, handler.Config, handler.Tokens, handler.Keys, userData.UserID, userData.Login, userData.Roles)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Issue tokens error", http.StatusInternalServerError)
+			return
+		}
+		recordLogin(req.Context(), handler.Tokens, userData.UserID)
+
+		if handler.Config.OIDCSuccessURL != "" {
+			http.Redirect(res, req, handler.Config.OIDCSuccessURL, http.StatusFound)
+			return
+		}
+
+		res.Header().Set("Content-Type", "text/plain")
+		res.WriteHeader(http.StatusOK)
+		io.WriteString(res, "User authorized")
+	}
+}
+
+// createUser registers the first login of an identity. The login is taken
+// from the provider claims when it is valid and free, otherwise generated.
+// The password is random, the user can set one through a password reset.
+func (handler *OIDCHandler) createUser(ctx context.Context, idToken *oidc.IDToken) (*models.UserData, error) {
+	passwordHash, err := handler.Hasher.Hash(uuid.New().String())
+	if err != nil {
+		return nil, err
+	}
+
+	identity := models.UserIdentity{
+		Issuer:  handler.Provider.Issuer(),
+		Subject: idToken.Subject,
+		Email:   idToken.Email,
+	}
+
+	var candidates []string
+	for _, login := range []string{idToken.PreferredUsername, idToken.Email} {
+		if handler.Rules.ValidateLogin(login) == nil {
+			candidates = append(candidates, login)
+		}
+	}
+	candidates = append(candidates, "sso-"+strings.ReplaceAll(uuid.New().String(), "-", "")[:12])
+
+	for _, login := range candidates {
+		userData := models.UserData{
+			UserID:   uuid.New().String(),
+			Login:    login,
+			Password: passwordHash,
+		}
+
+		err = handler.Storage.InsertUserWithIdentity(ctx, &userData, &identity)
+		if errors.Is(err, dberrors.ErrLoginTaken) {
+			continue
+		}
+		if err != nil {
+			// A concurrent first login of the same identity may have won.
+			if existing, selectErr := handler.Storage.SelectUserByIdentity(ctx, identity.Issuer, identity.Subject); selectErr == nil {
+				return existing, nil
+			}
+			return nil, err
+		}
+
+		logger.Log.Info(fmt.Sprintf("User %s registered through OIDC as %s", userData.UserID, login))
+		return &userData, nil
+	}
+
+	return nil, dberrors.ErrLoginTaken
+}
+
+func oidcStateFromRequest(req *http.Request) (*models.OIDCState, error) {
+	cookie, err := req.Cookie(oidcStateCookie)
+	if err != nil {
+		return nil, err
+	}
+
+	stateJSON, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	var state models.OIDCState
+	if err = json.Unmarshal(stateJSON, &state); err != nil {
+		return nil, err
+	}
+	if state.State == "" || state.Nonce == "" || state.Verifier == "" {
+		return nil, errors.New("incomplete OIDC state")
+	}
+
+	return &state, nil
+}
@@ -1,13 +1,18 @@
 package handler
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-resty/resty/v2"
@@ -23,11 +28,19 @@ import (
 
 type OrdersStorage interface {
 	InsertOrderData(context.Context, *models.OrderData) error
+	InsertOrdersBatch(context.Context, string, []int64, time.Time) (map[int64]string, error)
 	SelectOrdersByUserID(context.Context, string) ([]models.GetUserOrdersResponse, error)
+	SelectUserOrdersPage(context.Context, string, *models.OrdersQuery) ([]models.GetUserOrdersResponse, error)
+	SelectUserOrder(context.Context, string, int64) (*models.GetUserOrderResponse, error)
+	SelectOrderEvents(context.Context, string, int64) ([]models.OrderEvent, error)
 	SelectUnprocessedOrders(ctx context.Context, limit int) ([]models.OrderData, error)
 	UpdateOrder(context.Context, *models.OrderData) error
 }
 
+// batchItemMaxBytes bounds one batch item: a 19 digit number with quotes,
+// separator and some whitespace.
+const batchItemMaxBytes = 64
+
 type OrdersHandler struct {
 	Config              *config.Config
 	Storage             OrdersStorage
@@ -35,7 +48,7 @@ type OrdersHandler struct {
 	UnprocessedOrdersCh chan models.OrderData
 }
 
-func NewOrdersHandler(router *mux.Router, cfg *config.Config, storage OrdersStorage) {
+func NewOrdersHandler(router *mux.Router, cfg *config.Config, storage OrdersStorage, authenticate middleware.Authenticator) {
 
 	handler := &OrdersHandler{
 		Config:              cfg,
@@ -44,12 +57,21 @@ func NewOrdersHandler(router *mux.Router, cfg *config.Config, storage OrdersStor
 		UnprocessedOrdersCh: make(chan models.OrderData, 1024),
 	}
 
-	middlewareStack := middleware.Chain(
+	writeMiddlewareStack := middleware.Chain(
+		middleware.RequestLogger,
+		authenticate(auth.ScopeOrdersWrite),
+	)
+
+	readMiddlewareStack := middleware.Chain(
 		middleware.RequestLogger,
+		authenticate(auth.ScopeOrdersRead),
 	)
 
-	router.HandleFunc(`/api/user/orders`, middlewareStack(handler.CreateOrder())).Methods("POST")
-	router.HandleFunc(`/api/user/orders`, middlewareStack(handler.GetUserOrders())).Methods("GET")
+	router.HandleFunc(`/api/user/orders`, writeMiddlewareStack(handler.CreateOrder())).Methods("POST")
+	router.HandleFunc(`/api/user/orders/batch`, writeMiddlewareStack(handler.CreateOrdersBatch())).Methods("POST")
+	router.HandleFunc(`/api/user/orders`, readMiddlewareStack(handler.GetUserOrders())).Methods("GET")
+	router.HandleFunc(`/api/user/orders/{number:[0-9]+}`, readMiddlewareStack(handler.GetUserOrder())).Methods("GET")
+	router.HandleFunc(`/api/user/orders/{number:[0-9]+}/history`, readMiddlewareStack(handler.GetOrderHistory())).Methods("GET")
 
 	go handler.GetAccrualPoints()
 	go handler.SaveOrdersPoints()
@@ -57,20 +79,13 @@ func NewOrdersHandler(router *mux.Router, cfg *config.Config, storage OrdersStor
 
 func (handler *OrdersHandler) CreateOrder() http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
-		token, err := req.Cookie("token")
-
-		if err != nil {
-			logger.Log.Info(err.Error())
-			res.WriteHeader(http.StatusUnauthorized)
-			return
-		}
-
-		userID, err := auth.GetUserID(token.Value, handler.Config.SecretKey)
-		if err != nil {
-			logger.Log.Info(err.Error())
-			http.Error(res, err.Error(), http.StatusBadRequest)
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
+			logger.Log.Info("User unauthorized")
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
 			return
 		}
+		userID := claims.UserID
 
 		body, err := io.ReadAll(req.Body)
 		if err != nil {
@@ -96,7 +111,7 @@ func (handler *OrdersHandler) CreateOrder() http.HandlerFunc {
 		orderData := models.OrderData{
 			Number:     intBody,
 			UserID:     userID,
-			Status:     "NEW",
+			Status:     models.OrderStatusNew,
 			UploadedAt: time.Now(),
 		}
 		err = handler.Storage.InsertOrderData(req.Context(), &orderData)
@@ -121,20 +136,175 @@ func (handler *OrdersHandler) CreateOrder() http.HandlerFunc {
 	}
 }
 
-func (handler *OrdersHandler) GetUserOrders() http.HandlerFunc {
+// CreateOrdersBatch accepts a JSON array of numbers or a CSV body with the
+// number in the first column. Every item gets its own result in input order.
+func (handler *OrdersHandler) CreateOrdersBatch() http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
-		token, err := req.Cookie("token")
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
+			logger.Log.Info("User unauthorized")
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
+			return
+		}
+		userID := claims.UserID
 
+		// The body is capped before reading so an oversized batch is never
+		// buffered whole.
+		maxBytes := int64(handler.Config.OrdersBatchMaxSize) * batchItemMaxBytes
+		body, err := io.ReadAll(http.MaxBytesReader(res, req.Body, maxBytes))
 		if err != nil {
-			logger.Log.Info("User unauthorized")
-			res.WriteHeader(http.StatusUnauthorized)
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				http.Error(res, fmt.Sprintf("Batch exceeds %d bytes", maxBytes), http.StatusRequestEntityTooLarge)
+				return
+			}
+			logger.Log.Info(err.Error())
+			http.Error(res, "Invalid body", http.StatusBadRequest)
+			return
+		}
+
+		var items []string
+		mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		switch mediaType {
+		case "application/json", "":
+			items, err = batchItemsFromJSON(body)
+		case "text/csv":
+			items, err = batchItemsFromCSV(body)
+		default:
+			http.Error(res, "Expected application/json or text/csv", http.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Invalid body", http.StatusBadRequest)
+			return
+		}
+
+		if len(items) == 0 {
+			http.Error(res, "No orders in batch", http.StatusBadRequest)
+			return
+		}
+		if len(items) > handler.Config.OrdersBatchMaxSize {
+			http.Error(res, fmt.Sprintf("Batch exceeds %d orders", handler.Config.OrdersBatchMaxSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		results := make([]models.BatchOrderResult, len(items))
+		numbers := make([]int64, 0, len(items))
+		seen := make(map[int64]bool, len(items))
+		for i, item := range items {
+			results[i] = models.BatchOrderResult{Number: item, Status: models.BatchOrderInvalid}
+
+			number, err := strconv.ParseInt(item, 10, 64)
+			if err != nil || number <= 0 || !luhn.IsValid(number) {
+				continue
+			}
+			if !seen[number] {
+				seen[number] = true
+				numbers = append(numbers, number)
+			}
+		}
+
+		var stored map[int64]string
+		if len(numbers) > 0 {
+			stored, err = handler.Storage.InsertOrdersBatch(req.Context(), userID, numbers, time.Now())
+			if err != nil {
+				logger.Log.Info(err.Error())
+				http.Error(res, "Create orders error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// A number repeated in the batch is accepted once, the repeats are
+		// already uploaded by the user.
+		reported := make(map[int64]bool, len(numbers))
+		for i := range results {
+			number, err := strconv.ParseInt(results[i].Number, 10, 64)
+			if err != nil || !seen[number] {
+				continue
+			}
+			results[i].Status = stored[number]
+			if reported[number] && results[i].Status == models.BatchOrderAccepted {
+				results[i].Status = models.BatchOrderDuplicate
+			}
+			reported[number] = true
+		}
+
+		logger.Log.Info(fmt.Sprintf("User %s uploaded a batch of %d orders, %d valid", userID, len(items), len(numbers)))
+
+		resp, err := json.Marshal(results)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		userID, err := auth.GetUserID(token.Value, handler.Config.SecretKey)
+		res.Header().Set("Content-Type", "application/json")
+		res.WriteHeader(http.StatusOK)
+		_, err = res.Write(resp)
+
 		if err != nil {
 			logger.Log.Info(err.Error())
-			http.Error(res, err.Error(), http.StatusBadRequest)
+		}
+	}
+}
+
+// batchItemsFromJSON accepts both numbers and strings, anything else is kept
+// as is and reported invalid.
+func batchItemsFromJSON(body []byte) ([]string, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, err
+	}
+
+	items := make([]string, 0, len(raw))
+	for _, value := range raw {
+		var item string
+		if err := json.Unmarshal(value, &item); err != nil {
+			item = string(value)
+		}
+		items = append(items, strings.TrimSpace(item))
+	}
+
+	return items, nil
+}
+
+func batchItemsFromCSV(body []byte) ([]string, error) {
+	reader := csv.NewReader(bytes.NewReader(body))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]string, 0, len(records))
+	for _, record := range records {
+		if len(record) == 0 || strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		items = append(items, strings.TrimSpace(record[0]))
+	}
+
+	return items, nil
+}
+
+func (handler *OrdersHandler) GetUserOrders() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
+			logger.Log.Info("User unauthorized")
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
+			return
+		}
+		userID := claims.UserID
+
+		// The paginated shape is opt-in: a client that sends none of the
+		// paging parameters gets the array of the original contract.
+		if !handler.Config.OrdersLegacyList && wantsOrdersPage(req) {
+			handler.writeOrdersPage(res, req, userID)
+			return
 		}
 
 		if data, err := handler.Storage.SelectOrdersByUserID(req.Context(), userID); data != nil {
@@ -165,6 +335,229 @@ func (handler *OrdersHandler) GetUserOrders() http.HandlerFunc {
 	}
 }
 
+func (handler *OrdersHandler) writeOrdersPage(res http.ResponseWriter, req *http.Request, userID string) {
+	query, err := ordersQueryFromRequest(req, handler.Config)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// One extra row tells whether there is a next page.
+	limit := query.Limit
+	query.Limit++
+
+	orders, err := handler.Storage.SelectUserOrdersPage(req.Context(), userID, query)
+	if err != nil {
+		logger.Log.Info(err.Error())
+		http.Error(res, "Get orders error", http.StatusInternalServerError)
+		return
+	}
+
+	page := models.GetUserOrdersPage{Orders: orders}
+	if len(orders) > limit {
+		page.Orders = orders[:limit]
+		last := page.Orders[limit-1]
+		number, _ := strconv.ParseInt(last.Number, 10, 64)
+		page.NextCursor = encodeOrdersCursor(models.OrdersCursor{UploadedAt: last.UploadedAt, Number: number})
+	}
+
+	resp, err := json.Marshal(page)
+	if err != nil {
+		logger.Log.Info(err.Error())
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	_, err = res.Write(resp)
+
+	if err != nil {
+		logger.Log.Info(err.Error())
+	}
+}
+
+func wantsOrdersPage(req *http.Request) bool {
+	params := req.URL.Query()
+	for _, name := range []string{"limit", "cursor", "status", "from", "to", "sort"} {
+		if params.Has(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// ordersQueryFromRequest reads limit, cursor, status (comma separated),
+// from and to (RFC 3339, to is exclusive) and sort (asc or desc, newest
+// first by default).
+func ordersQueryFromRequest(req *http.Request, cfg *config.Config) (*models.OrdersQuery, error) {
+	params := req.URL.Query()
+	query := models.OrdersQuery{Limit: cfg.OrdersPageSize, Descending: true}
+
+	if limit := params.Get("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value <= 0 {
+			return nil, errors.New("invalid limit")
+		}
+		query.Limit = min(value, cfg.OrdersPageMaxSize)
+	}
+
+	if statuses := params.Get("status"); statuses != "" {
+		for _, status := range strings.Split(statuses, ",") {
+			status := models.OrderStatus(strings.ToUpper(strings.TrimSpace(status)))
+			if !status.Valid() {
+				return nil, fmt.Errorf("invalid status %q", status)
+			}
+			query.Statuses = append(query.Statuses, string(status))
+		}
+	}
+
+	for name, bound := range map[string]**time.Time{"from": &query.From, "to": &query.To} {
+		if value := params.Get(name); value != "" {
+			at, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: expected RFC 3339 time", name)
+			}
+			*bound = &at
+		}
+	}
+
+	switch strings.ToLower(params.Get("sort")) {
+	case "", "desc":
+	case "asc":
+		query.Descending = false
+	default:
+		return nil, errors.New("invalid sort: expected asc or desc")
+	}
+
+	if cursor := params.Get("cursor"); cursor != "" {
+		after, err := decodeOrdersCursor(cursor)
+		if err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+		query.After = after
+	}
+
+	return &query, nil
+}
+
+// The cursor is opaque to clients. Timestamps are kept to the microsecond,
+// the precision Postgres stores.
+func encodeOrdersCursor(cursor models.OrdersCursor) string {
+	return base64.RawURLEncoding.EncodeToString(fmt.Appendf(nil, "%d:%d", cursor.UploadedAt.UnixMicro(), cursor.Number))
+}
+
+func decodeOrdersCursor(value string) (*models.OrdersCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	micros, number, found := strings.Cut(string(raw), ":")
+	if !found {
+		return nil, errors.New("malformed cursor")
+	}
+
+	at, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	cursor := models.OrdersCursor{UploadedAt: time.UnixMicro(at)}
+	if cursor.Number, err = strconv.ParseInt(number, 10, 64); err != nil {
+		return nil, err
+	}
+
+	return &cursor, nil
+}
+
+// GetUserOrder answers 404 both for unknown orders and for orders of other
+// users, so the endpoint does not reveal which numbers are taken.
+func (handler *OrdersHandler) GetUserOrder() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
+			logger.Log.Info("User unauthorized")
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		number, err := strconv.ParseInt(mux.Vars(req)["number"], 10, 64)
+		if err != nil {
+			http.Error(res, "Order not found", http.StatusNotFound)
+			return
+		}
+
+		order, err := handler.Storage.SelectUserOrder(req.Context(), claims.UserID, number)
+		if err != nil {
+			if errors.Is(err, dberrors.ErrNotFound) {
+				http.Error(res, "Order not found", http.StatusNotFound)
+				return
+			}
+			logger.Log.Info(err.Error())
+			http.Error(res, "Get order error", http.StatusInternalServerError)
+			return
+		}
+
+		resp, err := json.Marshal(order)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		res.WriteHeader(http.StatusOK)
+		_, err = res.Write(resp)
+
+		if err != nil {
+			logger.Log.Info(err.Error())
+		}
+	}
+}
+
+func (handler *OrdersHandler) GetOrderHistory() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
+			logger.Log.Info("User unauthorized")
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		number, err := strconv.ParseInt(mux.Vars(req)["number"], 10, 64)
+		if err != nil {
+			http.Error(res, "Order not found", http.StatusNotFound)
+			return
+		}
+
+		events, err := handler.Storage.SelectOrderEvents(req.Context(), claims.UserID, number)
+		if err != nil {
+			if errors.Is(err, dberrors.ErrNotFound) {
+				http.Error(res, "Order not found", http.StatusNotFound)
+				return
+			}
+			logger.Log.Info(err.Error())
+			http.Error(res, "Get order history error", http.StatusInternalServerError)
+			return
+		}
+
+		resp, err := json.Marshal(events)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		res.WriteHeader(http.StatusOK)
+		_, err = res.Write(resp)
+
+		if err != nil {
+			logger.Log.Info(err.Error())
+		}
+	}
+}
+
 func (handler *OrdersHandler) SaveOrdersPoints() {
 	ticker := time.NewTicker(handler.Config.TickerPeriod)
 
@@ -183,6 +576,10 @@ func (handler *OrdersHandler) SaveOrdersPoints() {
 
 			for _, order := range OrdersForUpdate {
 				err := handler.Storage.UpdateOrder(context.Background(), &order)
+				if errors.Is(err, dberrors.ErrInvalidTransition) {
+					logger.Log.Info("Accrual status rejected: " + err.Error())
+					continue
+				}
 				if err != nil {
 					logger.Log.Info(err.Error())
 					continue
@@ -241,9 +638,10 @@ func (handler *OrdersHandler) worker() {
 			logger.Log.Info(err.Error())
 			continue
 		}
-		if accrualData.Status == "PROCESSING" || accrualData.Status == "REGISTERED" || accrualData.Status == "PROCESSED" || accrualData.Status == "INVALID" {
+		status := models.OrderStatus(accrualData.Status)
+		if status.Valid() && status != models.OrderStatusNew {
 			order.Accrual = accrualData.Accrual
-			order.Status = accrualData.Status
+			order.Status = status
 
 			handler.SaveAccrualPointsCh <- order
 		}
@@ -0,0 +1,249 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nu-kotov/gophermart/internal/auth"
+	"github.com/nu-kotov/gophermart/internal/config"
+	"github.com/nu-kotov/gophermart/internal/logger"
+	"github.com/nu-kotov/gophermart/internal/middleware"
+	"github.com/nu-kotov/gophermart/internal/models"
+	"github.com/nu-kotov/gophermart/internal/notifier"
+	"github.com/nu-kotov/gophermart/internal/storage/dberrors"
+	"github.com/nu-kotov/gophermart/internal/validation"
+)
+
+type PasswordsStorage interface {
+	SelectUserData(context.Context, *models.UserData) (*models.UserData, error)
+	SelectUserByID(context.Context, string) (*models.UserData, error)
+	UpdatePassword(context.Context, string, string) error
+	InsertPasswordResetToken(context.Context, *models.PasswordResetToken) error
+	ResetPassword(context.Context, string, string) error
+}
+
+type PasswordsHandler struct {
+	Config   *config.Config
+	Storage  PasswordsStorage
+	Tokens   TokensStorage
+	Keys     *auth.KeySet
+	Notifier notifier.Notifier
+	Rules    *validation.Rules
+	Hasher   *auth.PasswordHasher
+}
+
+func NewPasswordsHandler(router *mux.Router, cfg *config.Config, storage PasswordsStorage, tokens TokensStorage, keys *auth.KeySet, notify notifier.Notifier, rules *validation.Rules, authenticate middleware.Authenticator) {
+
+	handler := &PasswordsHandler{
+		Config:   cfg,
+		Storage:  storage,
+		Tokens:   tokens,
+		Keys:     keys,
+		Notifier: notify,
+		Rules:    rules,
+		Hasher:   auth.NewPasswordHasher(uint32(cfg.Argon2Memory), uint32(cfg.Argon2Iterations), uint8(cfg.Argon2Parallelism)),
+	}
+
+	middlewareStack := middleware.Chain(
+		middleware.RequestLogger,
+	)
+
+	authMiddlewareStack := middleware.Chain(
+		middleware.RequestLogger,
+		authenticate(),
+	)
+
+	router.HandleFunc(`/api/user/password`, authMiddlewareStack(handler.ChangePassword())).Methods("POST")
+	router.HandleFunc(`/api/user/password/reset`, middlewareStack(handler.RequestPasswordReset())).Methods("POST")
+	router.HandleFunc(`/api/user/password/reset/confirm`, middlewareStack(handler.ConfirmPasswordReset())).Methods("POST")
+}
+
+func (handler *PasswordsHandler) ChangePassword() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
+			logger.Log.Info("User unauthorized")
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Invalid body", http.StatusBadRequest)
+			return
+		}
+
+		var jsonBody models.ChangePasswordRequest
+		if err = json.Unmarshal(body, &jsonBody); err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err = handler.Rules.ValidatePassword(jsonBody.NewPassword); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		userData, err := handler.Storage.SelectUserByID(req.Context(), claims.UserID)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Change password error", http.StatusInternalServerError)
+			return
+		}
+
+		match, _, err := handler.Hasher.Compare(jsonBody.OldPassword, userData.Password)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Password comparing error", http.StatusInternalServerError)
+			return
+		}
+		if !match {
+			http.Error(res, "Incorrect password", http.StatusForbidden)
+			return
+		}
+
+		passwordHash, err := handler.Hasher.Hash(jsonBody.NewPassword)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		err = handler.Storage.UpdatePassword(req.Context(), claims.UserID, passwordHash)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Change password error", http.StatusInternalServerError)
+			return
+		}
+
+		// Every session, including the current one, has just been ended, so
+		// the caller gets a fresh one instead of being logged out.
+		err = issueTokens(res, req, handler.Config, handler.Tokens, handler.Keys, userData.UserID, userData.Login, userData.Roles)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Issue tokens error", http.StatusInternalServerError)
+			return
+		}
+
+		res.Header().Set("Content-Type", "text/plain")
+		res.WriteHeader(http.StatusOK)
+		io.WriteString(res, "Password changed")
+	}
+}
+
+func (handler *PasswordsHandler) RequestPasswordReset() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Invalid body", http.StatusBadRequest)
+			return
+		}
+
+		var jsonBody models.PasswordResetRequest
+		if err = json.Unmarshal(body, &jsonBody); err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if jsonBody.Login == "" {
+			http.Error(res, "Login is required", http.StatusBadRequest)
+			return
+		}
+
+		// The response is the same whether or not the login exists, so the
+		// endpoint cannot be used to enumerate accounts.
+		res.Header().Set("Content-Type", "text/plain")
+
+		userData, err := handler.Storage.SelectUserData(req.Context(), &models.UserData{Login: jsonBody.Login})
+		if err != nil {
+			if !errors.Is(err, dberrors.ErrNotFound) {
+				logger.Log.Info(err.Error())
+				http.Error(res, "Password reset error", http.StatusInternalServerError)
+				return
+			}
+			res.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		token, err := auth.NewRandomToken()
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Password reset error", http.StatusInternalServerError)
+			return
+		}
+
+		err = handler.Storage.InsertPasswordResetToken(req.Context(), &models.PasswordResetToken{
+			TokenHash: auth.HashToken(token),
+			UserID:    userData.UserID,
+			ExpiresAt: time.Now().Add(handler.Config.PasswordResetExp),
+		})
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Password reset error", http.StatusInternalServerError)
+			return
+		}
+
+		if err = handler.Notifier.NotifyPasswordReset(req.Context(), jsonBody.Login, token); err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Password reset error", http.StatusInternalServerError)
+			return
+		}
+
+		res.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (handler *PasswordsHandler) ConfirmPasswordReset() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Invalid body", http.StatusBadRequest)
+			return
+		}
+
+		var jsonBody models.PasswordResetConfirmRequest
+		if err = json.Unmarshal(body, &jsonBody); err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if jsonBody.Token == "" {
+			http.Error(res, "Token is required", http.StatusBadRequest)
+			return
+		}
+		if err = handler.Rules.ValidatePassword(jsonBody.NewPassword); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		passwordHash, err := handler.Hasher.Hash(jsonBody.NewPassword)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		err = handler.Storage.ResetPassword(req.Context(), auth.HashToken(jsonBody.Token), passwordHash)
+		if err != nil {
+			if errors.Is(err, dberrors.ErrNotFound) {
+				http.Error(res, "Invalid or expired reset token", http.StatusBadRequest)
+				return
+			}
+			logger.Log.Info(err.Error())
+			http.Error(res, "Password reset error", http.StatusInternalServerError)
+			return
+		}
+
+		res.Header().Set("Content-Type", "text/plain")
+		res.WriteHeader(http.StatusOK)
+		io.WriteString(res, "Password changed")
+	}
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/nu-kotov/gophermart/internal/auth"
+	"github.com/nu-kotov/gophermart/internal/config"
+	"github.com/nu-kotov/gophermart/internal/logger"
+	"github.com/nu-kotov/gophermart/internal/middleware"
+	"github.com/nu-kotov/gophermart/internal/models"
+	"github.com/nu-kotov/gophermart/internal/storage/dberrors"
+)
+
+const displayNameMaxLength = 100
+
+type ProfileStorage interface {
+	SelectUserProfile(context.Context, string) (*models.UserProfile, error)
+	UpdateUserProfile(context.Context, string, *models.UpdateProfileRequest) error
+}
+
+type ProfileHandler struct {
+	Config  *config.Config
+	Storage ProfileStorage
+}
+
+func NewProfileHandler(router *mux.Router, cfg *config.Config, storage ProfileStorage, authenticate middleware.Authenticator) {
+
+	handler := &ProfileHandler{
+		Config:  cfg,
+		Storage: storage,
+	}
+
+	middlewareStack := middleware.Chain(
+		middleware.RequestLogger,
+		authenticate(),
+	)
+
+	router.HandleFunc(`/api/user/me`, middlewareStack(handler.GetProfile())).Methods("GET")
+	router.HandleFunc(`/api/user/me`, middlewareStack(handler.UpdateProfile())).Methods("PATCH")
+}
+
+func (handler *ProfileHandler) GetProfile() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
+			logger.Log.Info("User unauthorized")
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler.writeProfile(res, req, claims.UserID)
+	}
+}
+
+func (handler *ProfileHandler) UpdateProfile() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
+			logger.Log.Info("User unauthorized")
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Invalid body", http.StatusBadRequest)
+			return
+		}
+
+		// Unknown fields are rejected so that an attempt to change a read-only
+		// field such as the login fails loudly instead of being ignored.
+		var jsonBody models.UpdateProfileRequest
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.DisallowUnknownFields()
+		if err = decoder.Decode(&jsonBody); err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if jsonBody.DisplayName != nil {
+			displayName := strings.TrimSpace(*jsonBody.DisplayName)
+			if utf8.RuneCountInString(displayName) > displayNameMaxLength {
+				http.Error(res, "Display name is too long", http.StatusBadRequest)
+				return
+			}
+			jsonBody.DisplayName = &displayName
+		}
+
+		err = handler.Storage.UpdateUserProfile(req.Context(), claims.UserID, &jsonBody)
+		if err != nil {
+			if errors.Is(err, dberrors.ErrNotFound) {
+				http.Error(res, "User not found", http.StatusNotFound)
+				return
+			}
+			logger.Log.Info(err.Error())
+			http.Error(res, "Update profile error", http.StatusInternalServerError)
+			return
+		}
+
+		handler.writeProfile(res, req, claims.UserID)
+	}
+}
+
+func (handler *ProfileHandler) writeProfile(res http.ResponseWriter, req *http.Request, userID string) {
+	profile, err := handler.Storage.SelectUserProfile(req.Context(), userID)
+	if err != nil {
+		if errors.Is(err, dberrors.ErrNotFound) {
+			http.Error(res, "User not found", http.StatusNotFound)
+			return
+		}
+		logger.Log.Info(err.Error())
+		http.Error(res, "Get profile error", http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(profile)
+	if err != nil {
+		logger.Log.Info(err.Error())
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	_, err = res.Write(resp)
+
+	if err != nil {
+		logger.Log.Info(err.Error())
+	}
+}
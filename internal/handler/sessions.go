@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/nu-kotov/gophermart/internal/auth"
+	"github.com/nu-kotov/gophermart/internal/config"
+	"github.com/nu-kotov/gophermart/internal/logger"
+	"github.com/nu-kotov/gophermart/internal/middleware"
+	"github.com/nu-kotov/gophermart/internal/models"
+	"github.com/nu-kotov/gophermart/internal/storage/dberrors"
+)
+
+type SessionsStorage interface {
+	SelectUserSessions(context.Context, string) ([]models.Session, error)
+	EndSession(context.Context, string, string) error
+}
+
+type SessionsHandler struct {
+	Config  *config.Config
+	Storage SessionsStorage
+}
+
+func NewSessionsHandler(router *mux.Router, cfg *config.Config, storage SessionsStorage, authenticate middleware.Authenticator) {
+
+	handler := &SessionsHandler{
+		Config:  cfg,
+		Storage: storage,
+	}
+
+	middlewareStack := middleware.Chain(
+		middleware.RequestLogger,
+		authenticate(),
+	)
+
+	router.HandleFunc(`/api/user/sessions`, middlewareStack(handler.GetUserSessions())).Methods("GET")
+	router.HandleFunc(`/api/user/sessions/{id}`, middlewareStack(handler.EndUserSession())).Methods("DELETE")
+}
+
+func (handler *SessionsHandler) GetUserSessions() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
+			logger.Log.Info("User unauthorized")
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		data, err := handler.Storage.SelectUserSessions(req.Context(), claims.UserID)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Get sessions error", http.StatusInternalServerError)
+			return
+		}
+		if len(data) == 0 {
+			res.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		for i := range data {
+			data[i].Current = data[i].SessionID == claims.SessionID
+		}
+
+		resp, err := json.Marshal(data)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		res.WriteHeader(http.StatusOK)
+		_, err = res.Write(resp)
+
+		if err != nil {
+			logger.Log.Info(err.Error())
+		}
+	}
+}
+
+func (handler *SessionsHandler) EndUserSession() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
+			logger.Log.Info("User unauthorized")
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		sessionID := mux.Vars(req)["id"]
+		if _, err := uuid.Parse(sessionID); err != nil {
+			http.Error(res, "Session not found", http.StatusNotFound)
+			return
+		}
+
+		err := handler.Storage.EndSession(req.Context(), claims.UserID, sessionID)
+		if err != nil {
+			if errors.Is(err, dberrors.ErrNotFound) {
+				http.Error(res, "Session not found", http.StatusNotFound)
+				return
+			}
+			logger.Log.Info(err.Error())
+			http.Error(res, "End session error", http.StatusInternalServerError)
+			return
+		}
+
+		if sessionID == claims.SessionID {
+			clearTokenCookies(res, handler.Config)
+		}
+
+		res.Header().Set("Content-Type", "text/plain")
+		res.WriteHeader(http.StatusOK)
+		io.WriteString(res, "Session ended")
+	}
+}
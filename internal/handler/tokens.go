@@ -0,0 +1,300 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/nu-kotov/gophermart/internal/auth"
+	"github.com/nu-kotov/gophermart/internal/config"
+	"github.com/nu-kotov/gophermart/internal/logger"
+	"github.com/nu-kotov/gophermart/internal/middleware"
+	"github.com/nu-kotov/gophermart/internal/models"
+	"github.com/nu-kotov/gophermart/internal/storage/dberrors"
+)
+
+const (
+	accessTokenCookie  = "token"
+	refreshTokenCookie = "refresh_token"
+	refreshTokenPath   = "/api/user"
+)
+
+type TokensStorage interface {
+	InsertSession(context.Context, *models.Session, *models.RefreshToken) error
+	UpdateLastLogin(context.Context, string, time.Time) error
+	RotateRefreshToken(context.Context, string, *models.RefreshToken) error
+	EndSession(context.Context, string, string) error
+	RevokeAccessToken(context.Context, string, time.Time) error
+	IsRevoked(context.Context, *auth.Claims) (bool, error)
+}
+
+type TokensHandler struct {
+	Config  *config.Config
+	Storage TokensStorage
+	Keys    *auth.KeySet
+}
+
+func NewTokensHandler(router *mux.Router, cfg *config.Config, storage TokensStorage, keys *auth.KeySet, authenticate middleware.Authenticator) {
+
+	handler := &TokensHandler{
+		Config:  cfg,
+		Storage: storage,
+		Keys:    keys,
+	}
+
+	middlewareStack := middleware.Chain(
+		middleware.RequestLogger,
+	)
+
+	authMiddlewareStack := middleware.Chain(
+		middleware.RequestLogger,
+		authenticate(),
+	)
+
+	router.HandleFunc(`/api/user/token/refresh`, middlewareStack(handler.RefreshToken())).Methods("POST")
+	router.HandleFunc(`/api/user/logout`, authMiddlewareStack(handler.Logout())).Methods("POST")
+}
+
+func (handler *TokensHandler) RefreshToken() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		refreshToken, fromCookie, err := refreshTokenFromRequest(req)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Invalid body", http.StatusBadRequest)
+			return
+		}
+		if refreshToken == "" {
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// The route is outside Authenticate, the cookie still needs the
+		// double-submit check it applies.
+		if handler.Config.CSRFProtection && fromCookie && !middleware.ValidCSRF(req) {
+			logger.Log.Info("CSRF token missing or invalid for " + req.URL.Path)
+			http.Error(res, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+
+		newRefreshToken, err := auth.NewRandomToken()
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Refresh token error", http.StatusInternalServerError)
+			return
+		}
+
+		rotated := models.RefreshToken{
+			TokenHash: auth.HashToken(newRefreshToken),
+			ExpiresAt: time.Now().Add(handler.Config.RefreshTokenExp),
+		}
+		err = handler.Storage.RotateRefreshToken(req.Context(), auth.HashToken(refreshToken), &rotated)
+		if err != nil {
+			if errors.Is(err, dberrors.ErrNotFound) || errors.Is(err, dberrors.ErrTokenExpired) || errors.Is(err, dberrors.ErrTokenReused) {
+				logger.Log.Info("Refresh token rejected: " + err.Error())
+				http.Error(res, "User unauthorized", http.StatusUnauthorized)
+				return
+			}
+			logger.Log.Info(err.Error())
+			http.Error(res, "Refresh token error", http.StatusInternalServerError)
+			return
+		}
+
+		accessToken, err := auth.BuildJWTString(
+			rotated.UserID,
+			rotated.Login,
+			rotated.SessionID,
+			rotated.Roles,
+			handler.Config.TokenExp,
+			handler.Keys,
+		)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Refresh token error", http.StatusInternalServerError)
+			return
+		}
+
+		if err = setTokenCookies(res, handler.Config, accessToken, newRefreshToken); err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Refresh token error", http.StatusInternalServerError)
+			return
+		}
+
+		resp, err := json.Marshal(models.TokensResponse{
+			AccessToken:  accessToken,
+			RefreshToken: newRefreshToken,
+			ExpiresIn:    int64(handler.Config.TokenExp.Seconds()),
+		})
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		res.WriteHeader(http.StatusOK)
+		_, err = res.Write(resp)
+
+		if err != nil {
+			logger.Log.Info(err.Error())
+		}
+	}
+}
+
+func (handler *TokensHandler) Logout() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
+			logger.Log.Info("User unauthorized")
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		err := handler.Storage.RevokeAccessToken(req.Context(), claims.ID, claims.ExpiresAt.Time)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Logout error", http.StatusInternalServerError)
+			return
+		}
+
+		if claims.SessionID != "" {
+			err = handler.Storage.EndSession(req.Context(), claims.UserID, claims.SessionID)
+			if err != nil && !errors.Is(err, dberrors.ErrNotFound) {
+				logger.Log.Info(err.Error())
+				http.Error(res, "Logout error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		clearTokenCookies(res, handler.Config)
+		res.Header().Set("Content-Type", "text/plain")
+		res.WriteHeader(http.StatusOK)
+		io.WriteString(res, "User logged out")
+	}
+}
+
+func issueTokens(res http.ResponseWriter, req *http.Request, cfg *config.Config, storage TokensStorage, keys *auth.KeySet, userID string, login string, roles []string) error {
+	now := time.Now()
+	session := models.Session{
+		SessionID: uuid.New().String(),
+		UserID:    userID,
+		UserAgent: req.UserAgent(),
+		IP:        clientIP(req),
+		CreatedAt: now,
+		ExpiresAt: now.Add(cfg.RefreshTokenExp),
+	}
+
+	accessToken, err := auth.BuildJWTString(userID, login, session.SessionID, roles, cfg.TokenExp, keys)
+	if err != nil {
+		return err
+	}
+
+	refreshToken, err := auth.NewRandomToken()
+	if err != nil {
+		return err
+	}
+
+	err = storage.InsertSession(req.Context(), &session, &models.RefreshToken{
+		TokenHash: auth.HashToken(refreshToken),
+		SessionID: session.SessionID,
+		UserID:    userID,
+		ExpiresAt: session.ExpiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	return setTokenCookies(res, cfg, accessToken, refreshToken)
+}
+
+// recordLogin is called by the login paths only: tokens are issued on
+// registration and password reset too, which are not logins.
+func recordLogin(ctx context.Context, storage TokensStorage, userID string) {
+	if err := storage.UpdateLastLogin(ctx, userID, time.Now()); err != nil {
+		logger.Log.Info(err.Error())
+	}
+}
+
+// setTokenCookies also issues a fresh CSRF token. It lives as long as the
+// refresh token and is readable by scripts, which must echo it in the
+// X-CSRF-Token header of state-changing requests.
+func setTokenCookies(res http.ResponseWriter, cfg *config.Config, accessToken string, refreshToken string) error {
+	csrfToken, err := auth.NewRandomToken()
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(res, newCookie(cfg, accessTokenCookie, accessToken, cfg.CookiePath, cfg.TokenExp, true))
+	http.SetCookie(res, newCookie(cfg, refreshTokenCookie, refreshToken, refreshTokenPath, cfg.RefreshTokenExp, true))
+	http.SetCookie(res, newCookie(cfg, middleware.CSRFCookie, csrfToken, cfg.CookiePath, cfg.RefreshTokenExp, false))
+
+	return nil
+}
+
+func clearTokenCookies(res http.ResponseWriter, cfg *config.Config) {
+	http.SetCookie(res, newCookie(cfg, accessTokenCookie, "", cfg.CookiePath, -1, true))
+	http.SetCookie(res, newCookie(cfg, refreshTokenCookie, "", refreshTokenPath, -1, true))
+	http.SetCookie(res, newCookie(cfg, middleware.CSRFCookie, "", cfg.CookiePath, -1, false))
+}
+
+// newCookie applies the configured attributes. A negative lifetime deletes
+// the cookie.
+func newCookie(cfg *config.Config, name string, value string, path string, lifetime time.Duration, httpOnly bool) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   cfg.CookieDomain,
+		Secure:   cfg.CookieSecure,
+		HttpOnly: httpOnly,
+		SameSite: cfg.CookieSameSiteMode(),
+	}
+
+	if lifetime < 0 {
+		cookie.MaxAge = -1
+		cookie.Expires = time.Unix(0, 0)
+		return cookie
+	}
+
+	cookie.MaxAge = int(lifetime.Seconds())
+	cookie.Expires = time.Now().Add(lifetime)
+
+	return cookie
+}
+
+// refreshTokenFromRequest also reports whether the token came from the
+// cookie rather than the body.
+func refreshTokenFromRequest(req *http.Request) (string, bool, error) {
+	if cookie, err := req.Cookie(refreshTokenCookie); err == nil && cookie.Value != "" {
+		return cookie.Value, true, nil
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return "", false, err
+	}
+	if len(body) == 0 {
+		return "", false, nil
+	}
+
+	var jsonBody models.RefreshTokenRequest
+	if err = json.Unmarshal(body, &jsonBody); err != nil {
+		return "", false, err
+	}
+
+	return jsonBody.RefreshToken, false, nil
+}
+
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,416 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nu-kotov/gophermart/internal/auth"
+	"github.com/nu-kotov/gophermart/internal/config"
+	"github.com/nu-kotov/gophermart/internal/logger"
+	"github.com/nu-kotov/gophermart/internal/middleware"
+	"github.com/nu-kotov/gophermart/internal/models"
+	"github.com/nu-kotov/gophermart/internal/storage/dberrors"
+	"github.com/nu-kotov/gophermart/internal/totp"
+)
+
+const recoveryCodesNum = 10
+
+type TwoFactorStorage interface {
+	SelectTwoFactor(context.Context, string) (*models.TwoFactor, error)
+	UpdateTOTPSecret(context.Context, string, string) error
+	EnableTwoFactor(context.Context, string, int64, []string) error
+	DisableTwoFactor(context.Context, string) error
+	UpdateTOTPStep(context.Context, string, int64) error
+	UseRecoveryCode(context.Context, string, string) error
+	SelectUserByID(context.Context, string) (*models.UserData, error)
+	HasLinkedIdentity(context.Context, string) (bool, error)
+	SelectSessionCreatedAt(context.Context, string, string) (time.Time, error)
+}
+
+type TwoFactorHandler struct {
+	Config   *config.Config
+	Storage  TwoFactorStorage
+	Tokens   TokensStorage
+	Attempts LoginAttemptsStorage
+	Keys     *auth.KeySet
+	Hasher   *auth.PasswordHasher
+}
+
+func NewTwoFactorHandler(router *mux.Router, cfg *config.Config, storage TwoFactorStorage, tokens TokensStorage, attempts LoginAttemptsStorage, keys *auth.KeySet, authenticate middleware.Authenticator) {
+
+	handler := &TwoFactorHandler{
+		Config:   cfg,
+		Storage:  storage,
+		Tokens:   tokens,
+		Attempts: attempts,
+		Keys:     keys,
+		Hasher:   auth.NewPasswordHasher(uint32(cfg.Argon2Memory), uint32(cfg.Argon2Iterations), uint8(cfg.Argon2Parallelism)),
+	}
+
+	middlewareStack := middleware.Chain(
+		middleware.RequestLogger,
+	)
+
+	authMiddlewareStack := middleware.Chain(
+		middleware.RequestLogger,
+		authenticate(),
+	)
+
+	router.HandleFunc(`/api/user/2fa/enroll`, authMiddlewareStack(handler.EnrollTOTP())).Methods("POST")
+	router.HandleFunc(`/api/user/2fa/enable`, authMiddlewareStack(handler.EnableTwoFactor())).Methods("POST")
+	router.HandleFunc(`/api/user/2fa/disable`, authMiddlewareStack(handler.DisableTwoFactor())).Methods("POST")
+	router.HandleFunc(`/api/user/2fa/verify`, middlewareStack(handler.VerifyTwoFactor())).Methods("POST")
+}
+
+func (handler *TwoFactorHandler) EnrollTOTP() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
+			logger.Log.Info("User unauthorized")
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Enroll 2FA error", http.StatusInternalServerError)
+			return
+		}
+
+		// The secret stays pending until a code generated from it is confirmed
+		// through /api/user/2fa/enable.
+		err = handler.Storage.UpdateTOTPSecret(req.Context(), claims.UserID, secret)
+		if err != nil {
+			if errors.Is(err, dberrors.ErrTwoFactorEnabled) {
+				http.Error(res, "2FA is already enabled", http.StatusConflict)
+				return
+			}
+			logger.Log.Info(err.Error())
+			http.Error(res, "Enroll 2FA error", http.StatusInternalServerError)
+			return
+		}
+
+		resp, err := json.Marshal(models.TwoFactorEnrollResponse{
+			Secret: secret,
+			URI:    totp.ProvisioningURI(handler.Config.TOTPIssuer, claims.Login, secret),
+		})
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		res.WriteHeader(http.StatusOK)
+		_, err = res.Write(resp)
+
+		if err != nil {
+			logger.Log.Info(err.Error())
+		}
+	}
+}
+
+func (handler *TwoFactorHandler) EnableTwoFactor() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
+			logger.Log.Info("User unauthorized")
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Invalid body", http.StatusBadRequest)
+			return
+		}
+
+		var jsonBody models.TwoFactorCodeRequest
+		if err = json.Unmarshal(body, &jsonBody); err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		twoFactor, err := handler.Storage.SelectTwoFactor(req.Context(), claims.UserID)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Enable 2FA error", http.StatusInternalServerError)
+			return
+		}
+		if twoFactor.Enabled {
+			http.Error(res, "2FA is already enabled", http.StatusConflict)
+			return
+		}
+		if twoFactor.Secret == "" {
+			http.Error(res, "2FA is not enrolled", http.StatusBadRequest)
+			return
+		}
+
+		attemptsKey := twoFactorAttemptsKey(claims.UserID)
+		if handler.attemptsLocked(res, req, attemptsKey, "Enable 2FA error") {
+			return
+		}
+
+		step, ok := totp.Validate(twoFactor.Secret, jsonBody.Code, time.Now())
+		if !ok {
+			handler.registerFailure(req.Context(), attemptsKey)
+			http.Error(res, "Incorrect code", http.StatusForbidden)
+			return
+		}
+
+		codes, err := totp.GenerateRecoveryCodes(recoveryCodesNum)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Enable 2FA error", http.StatusInternalServerError)
+			return
+		}
+
+		codeHashes := make([]string, 0, len(codes))
+		for _, code := range codes {
+			codeHashes = append(codeHashes, auth.HashToken(code))
+		}
+
+		err = handler.Storage.EnableTwoFactor(req.Context(), claims.UserID, step, codeHashes)
+		if err != nil {
+			if errors.Is(err, dberrors.ErrTwoFactorEnabled) {
+				http.Error(res, "2FA is already enabled", http.StatusConflict)
+				return
+			}
+			logger.Log.Info(err.Error())
+			http.Error(res, "Enable 2FA error", http.StatusInternalServerError)
+			return
+		}
+
+		if err = handler.Attempts.DeleteLoginFailures(req.Context(), attemptsKey); err != nil {
+			logger.Log.Info(err.Error())
+		}
+
+		resp, err := json.Marshal(models.RecoveryCodesResponse{RecoveryCodes: codes})
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		res.WriteHeader(http.StatusOK)
+		_, err = res.Write(resp)
+
+		if err != nil {
+			logger.Log.Info(err.Error())
+		}
+	}
+}
+
+func (handler *TwoFactorHandler) DisableTwoFactor() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
+			logger.Log.Info("User unauthorized")
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Invalid body", http.StatusBadRequest)
+			return
+		}
+
+		var jsonBody models.TwoFactorDisableRequest
+		if err = json.Unmarshal(body, &jsonBody); err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		attemptsKey := twoFactorAttemptsKey(claims.UserID)
+		if handler.attemptsLocked(res, req, attemptsKey, "Disable 2FA error") {
+			return
+		}
+
+		// A code alone is not enough: it may come from a stolen session on the
+		// same device that holds the authenticator.
+		confirmed, err := reauthenticated(req.Context(), handler.Config, handler.Storage, handler.Hasher, claims, jsonBody.Password)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Disable 2FA error", http.StatusInternalServerError)
+			return
+		}
+		if !confirmed {
+			handler.registerFailure(req.Context(), attemptsKey)
+			http.Error(res, "Incorrect password", http.StatusForbidden)
+			return
+		}
+
+		verified, err := handler.checkCode(req.Context(), claims.UserID, jsonBody.Code, jsonBody.RecoveryCode)
+		if err != nil {
+			if errors.Is(err, dberrors.ErrNotFound) {
+				http.Error(res, "2FA is not enabled", http.StatusBadRequest)
+				return
+			}
+			logger.Log.Info(err.Error())
+			http.Error(res, "Disable 2FA error", http.StatusInternalServerError)
+			return
+		}
+		if !verified {
+			handler.registerFailure(req.Context(), attemptsKey)
+			http.Error(res, "Incorrect code", http.StatusForbidden)
+			return
+		}
+
+		if err = handler.Attempts.DeleteLoginFailures(req.Context(), attemptsKey); err != nil {
+			logger.Log.Info(err.Error())
+		}
+
+		if err = handler.Storage.DisableTwoFactor(req.Context(), claims.UserID); err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Disable 2FA error", http.StatusInternalServerError)
+			return
+		}
+
+		res.Header().Set("Content-Type", "text/plain")
+		res.WriteHeader(http.StatusOK)
+		io.WriteString(res, "2FA disabled")
+	}
+}
+
+func (handler *TwoFactorHandler) VerifyTwoFactor() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Invalid body", http.StatusBadRequest)
+			return
+		}
+
+		var jsonBody models.TwoFactorVerifyRequest
+		if err = json.Unmarshal(body, &jsonBody); err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		claims, err := auth.ParsePurposeToken(req.Context(), jsonBody.PreAuthToken, auth.PurposeTwoFactor, handler.Keys, handler.Tokens)
+		if err != nil {
+			logger.Log.Info("Pre-auth token rejected: " + err.Error())
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		attemptsKey := twoFactorAttemptsKey(claims.UserID)
+		if handler.attemptsLocked(res, req, attemptsKey, "Verify 2FA error") {
+			return
+		}
+
+		verified, err := handler.checkCode(req.Context(), claims.UserID, jsonBody.Code, jsonBody.RecoveryCode)
+		if err != nil && !errors.Is(err, dberrors.ErrNotFound) {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Verify 2FA error", http.StatusInternalServerError)
+			return
+		}
+		if !verified {
+			handler.registerFailure(req.Context(), attemptsKey)
+			http.Error(res, "Incorrect code", http.StatusUnauthorized)
+			return
+		}
+
+		if err = handler.Attempts.DeleteLoginFailures(req.Context(), attemptsKey); err != nil {
+			logger.Log.Info(err.Error())
+		}
+
+		// The pre-auth token is single use.
+		if err = handler.Tokens.RevokeAccessToken(req.Context(), claims.ID, claims.ExpiresAt.Time); err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Verify 2FA error", http.StatusInternalServerError)
+			return
+		}
+
+		err = issueTokens(res, req, handler.Config, handler.Tokens, handler.Keys, claims.UserID, claims.Login, claims.Roles)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Issue tokens error", http.StatusInternalServerError)
+			return
+		}
+		recordLogin(req.Context(), handler.Tokens, claims.UserID)
+
+		res.Header().Set("Content-Type", "text/plain")
+		res.WriteHeader(http.StatusOK)
+		io.WriteString(res, "User authorized")
+	}
+}
+
+// twoFactorAttemptsKey is shared by every endpoint that checks a code, so
+// the attempts limit can not be bypassed by switching between them.
+func twoFactorAttemptsKey(userID string) string {
+	return "2fa:" + userID
+}
+
+// attemptsLocked answers the request with 429 while the code attempts of the
+// key are locked.
+func (handler *TwoFactorHandler) attemptsLocked(res http.ResponseWriter, req *http.Request, attemptsKey string, errMessage string) bool {
+	lockedUntil, err := handler.Attempts.SelectLockedUntil(req.Context(), []string{attemptsKey})
+	if err != nil {
+		logger.Log.Info(err.Error())
+		http.Error(res, errMessage, http.StatusInternalServerError)
+		return true
+	}
+	if retryAfter := time.Until(lockedUntil); retryAfter > 0 {
+		logger.Log.Info(fmt.Sprintf("2FA attempts for %s are locked", attemptsKey))
+		res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		http.Error(res, "Too many attempts", http.StatusTooManyRequests)
+		return true
+	}
+
+	return false
+}
+
+func (handler *TwoFactorHandler) registerFailure(ctx context.Context, attemptsKey string) {
+	registerLoginFailure(ctx, handler.Config, handler.Attempts, attemptsKey, handler.Config.TwoFactorMaxAttempts)
+}
+
+// checkCode accepts either a TOTP code, which must belong to a time step newer
+// than the last accepted one, or an unused recovery code, which is consumed.
+func (handler *TwoFactorHandler) checkCode(ctx context.Context, userID string, code string, recoveryCode string) (bool, error) {
+	twoFactor, err := handler.Storage.SelectTwoFactor(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	if !twoFactor.Enabled {
+		return false, dberrors.ErrNotFound
+	}
+
+	switch {
+	case code != "":
+		step, ok := totp.Validate(twoFactor.Secret, code, time.Now())
+		if !ok || step <= twoFactor.LastStep {
+			return false, nil
+		}
+		err = handler.Storage.UpdateTOTPStep(ctx, userID, step)
+	case recoveryCode != "":
+		err = handler.Storage.UseRecoveryCode(ctx, userID, auth.HashToken(totp.NormalizeRecoveryCode(recoveryCode)))
+	default:
+		return false, nil
+	}
+
+	if errors.Is(err, dberrors.ErrTokenReused) || errors.Is(err, dberrors.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
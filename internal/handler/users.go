@@ -3,11 +3,15 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/alexedwards/argon2id"
 	"github.com/google/uuid"
 	"github.com/nu-kotov/gophermart/internal/auth"
 
@@ -16,23 +20,52 @@ import (
 	"github.com/nu-kotov/gophermart/internal/logger"
 	"github.com/nu-kotov/gophermart/internal/middleware"
 	"github.com/nu-kotov/gophermart/internal/models"
+	"github.com/nu-kotov/gophermart/internal/storage/dberrors"
+	"github.com/nu-kotov/gophermart/internal/validation"
 )
 
 type UsersStorage interface {
 	InsertUserData(context.Context, *models.UserData) error
 	SelectUserData(context.Context, *models.UserData) (*models.UserData, error)
+	UpdatePasswordHash(context.Context, string, string, string) error
+}
+
+type LoginAttemptsStorage interface {
+	SelectLockedUntil(context.Context, []string) (time.Time, error)
+	InsertLoginFailure(context.Context, string, time.Duration) (int, error)
+	UpdateLockedUntil(context.Context, string, time.Time) error
+	DeleteLoginFailures(context.Context, string) error
 }
 
 type UsersHandler struct {
-	Config  *config.Config
-	Storage UsersStorage
+	Config    *config.Config
+	Storage   UsersStorage
+	Tokens    TokensStorage
+	Attempts  LoginAttemptsStorage
+	Keys      *auth.KeySet
+	Rules     *validation.Rules
+	Hasher    *auth.PasswordHasher
+	dummyHash string
 }
 
-func NewUsersHandler(router *mux.Router, cfg *config.Config, storage UsersStorage) {
+func NewUsersHandler(router *mux.Router, cfg *config.Config, storage UsersStorage, tokens TokensStorage, attempts LoginAttemptsStorage, keys *auth.KeySet, rules *validation.Rules) error {
+
+	hasher := auth.NewPasswordHasher(uint32(cfg.Argon2Memory), uint32(cfg.Argon2Iterations), uint8(cfg.Argon2Parallelism))
+
+	dummyHash, err := hasher.Hash(uuid.New().String())
+	if err != nil {
+		return err
+	}
 
 	handler := &UsersHandler{
-		Config:  cfg,
-		Storage: storage,
+		Config:    cfg,
+		Storage:   storage,
+		Tokens:    tokens,
+		Attempts:  attempts,
+		Keys:      keys,
+		Rules:     rules,
+		Hasher:    hasher,
+		dummyHash: dummyHash,
 	}
 
 	middlewareStack := middleware.Chain(
@@ -42,6 +75,7 @@ func NewUsersHandler(router *mux.Router, cfg *config.Config, storage UsersStorag
 	router.HandleFunc(`/api/user/register`, middlewareStack(handler.RegisterUser())).Methods("POST")
 	router.HandleFunc(`/api/user/login`, middlewareStack(handler.LoginUser())).Methods("POST")
 
+	return nil
 }
 
 func (handler *UsersHandler) RegisterUser() http.HandlerFunc {
@@ -60,7 +94,16 @@ func (handler *UsersHandler) RegisterUser() http.HandlerFunc {
 			return
 		}
 
-		passwordHash, err := argon2id.CreateHash(jsonBody.Password, argon2id.DefaultParams)
+		if err = handler.Rules.ValidateLogin(jsonBody.Login); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err = handler.Rules.ValidatePassword(jsonBody.Password); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		passwordHash, err := handler.Hasher.Hash(jsonBody.Password)
 		if err != nil {
 			logger.Log.Info(err.Error())
 			http.Error(res, err.Error(), http.StatusInternalServerError)
@@ -71,29 +114,23 @@ func (handler *UsersHandler) RegisterUser() http.HandlerFunc {
 
 		err = handler.Storage.InsertUserData(req.Context(), &jsonBody)
 		if err != nil {
+			if errors.Is(err, dberrors.ErrLoginTaken) {
+				logger.Log.Info(fmt.Sprintf("Login %s is already taken", jsonBody.Login))
+				http.Error(res, "Login is already taken", http.StatusConflict)
+				return
+			}
 			logger.Log.Info(err.Error())
 			http.Error(res, "Register user error", http.StatusInternalServerError)
 			return
 		}
 
-		value, err := auth.BuildJWTString(
-			jsonBody.UserID,
-			jsonBody.Login,
-			handler.Config.TokenExp,
-			handler.Config.SecretKey,
-		)
+		err = issueTokens(res, req, handler.Config, handler.Tokens, handler.Keys, jsonBody.UserID, jsonBody.Login, nil)
 		if err != nil {
 			logger.Log.Info(err.Error())
-			res.WriteHeader(http.StatusBadRequest)
+			http.Error(res, "Issue tokens error", http.StatusInternalServerError)
 			return
 		}
-		cookie := &http.Cookie{
-			Name:     "token",
-			Value:    value,
-			HttpOnly: true,
-		}
 
-		http.SetCookie(res, cookie)
 		res.Header().Set("Content-Type", "text/plain")
 		res.WriteHeader(http.StatusOK)
 		io.WriteString(res, fmt.Sprintf("User %s registered", jsonBody.Login))
@@ -116,44 +153,149 @@ func (handler *UsersHandler) LoginUser() http.HandlerFunc {
 			return
 		}
 
-		userData, err := handler.Storage.SelectUserData(req.Context(), &jsonBody)
+		loginKey, ipKey := "login:"+strings.ToLower(jsonBody.Login), "ip:"+clientIP(req)
+
+		lockedUntil, err := handler.Attempts.SelectLockedUntil(req.Context(), []string{loginKey, ipKey})
 		if err != nil {
 			logger.Log.Info(err.Error())
-			http.Error(res, "Get user password error", http.StatusInternalServerError)
+			http.Error(res, "Login error", http.StatusInternalServerError)
+			return
+		}
+		if retryAfter := time.Until(lockedUntil); retryAfter > 0 {
+			logger.Log.Info(fmt.Sprintf("Login for %s from %s is locked", jsonBody.Login, clientIP(req)))
+			res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(res, "Too many login attempts", http.StatusTooManyRequests)
+			return
+		}
+
+		userData, err := handler.Storage.SelectUserData(req.Context(), &jsonBody)
+		if err != nil && !errors.Is(err, dberrors.ErrNotFound) {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Login error", http.StatusInternalServerError)
 			return
 		}
 
-		match, err := argon2id.ComparePasswordAndHash(jsonBody.Password, userData.Password)
+		// Unknown logins are checked against a dummy hash so that they take
+		// as long as a wrong password and cannot be told apart by timing.
+		passwordHash := handler.dummyHash
+		if userData != nil {
+			passwordHash = userData.Password
+		}
+
+		match, rehash, err := handler.Hasher.Compare(jsonBody.Password, passwordHash)
 		if err != nil {
 			logger.Log.Info(err.Error())
 			http.Error(res, "Password comparing error", http.StatusInternalServerError)
 			return
 		}
-		if !match {
-			http.Error(res, "Uncorrect passwort or login", http.StatusUnauthorized)
+		if userData == nil || !match {
+			registerLoginFailure(req.Context(), handler.Config, handler.Attempts, loginKey, handler.Config.LoginMaxAttempts)
+			registerLoginFailure(req.Context(), handler.Config, handler.Attempts, ipKey, handler.Config.LoginMaxAttemptsPerIP)
+			http.Error(res, "Incorrect login or password", http.StatusUnauthorized)
 			return
 		}
 
-		value, err := auth.BuildJWTString(
-			userData.UserID,
-			userData.Login,
-			handler.Config.TokenExp,
-			handler.Config.SecretKey,
-		)
-		if err != nil {
+		if err = handler.Attempts.DeleteLoginFailures(req.Context(), loginKey); err != nil {
 			logger.Log.Info(err.Error())
-			res.WriteHeader(http.StatusBadRequest)
+		}
+
+		if rehash {
+			handler.rehashPassword(req.Context(), userData, jsonBody.Password)
+		}
+
+		if userData.TwoFactorEnabled {
+			requireTwoFactor(res, handler.Config, handler.Keys, userData.UserID, jsonBody.Login, userData.Roles)
 			return
 		}
-		cookie := &http.Cookie{
-			Name:     "token",
-			Value:    value,
-			HttpOnly: true,
+
+		err = issueTokens(res, req, handler.Config, handler.Tokens, handler.Keys, userData.UserID, jsonBody.Login, userData.Roles)
+		if err != nil {
+			logger.Log.Info(err.Error())
+			http.Error(res, "Issue tokens error", http.StatusInternalServerError)
+			return
 		}
+		recordLogin(req.Context(), handler.Tokens, userData.UserID)
 
-		http.SetCookie(res, cookie)
 		res.Header().Set("Content-Type", "text/plain")
 		res.WriteHeader(http.StatusOK)
 		io.WriteString(res, "User authorized")
 	}
 }
+
+// requireTwoFactor answers a correct password of a 2FA user with a pre-auth
+// token instead of a session. Only /api/user/2fa/verify accepts it.
+func requireTwoFactor(res http.ResponseWriter, cfg *config.Config, keys *auth.KeySet, userID string, login string, roles []string) {
+	preAuthToken, err := auth.BuildPurposeToken(userID, login, roles, auth.PurposeTwoFactor, cfg.PreAuthTokenExp, keys)
+	if err != nil {
+		logger.Log.Info(err.Error())
+		http.Error(res, "Issue tokens error", http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(models.PreAuthResponse{
+		TwoFactorRequired: true,
+		PreAuthToken:      preAuthToken,
+		ExpiresIn:         int64(cfg.PreAuthTokenExp.Seconds()),
+	})
+	if err != nil {
+		logger.Log.Info(err.Error())
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusAccepted)
+	_, err = res.Write(resp)
+
+	if err != nil {
+		logger.Log.Info(err.Error())
+	}
+}
+
+func (handler *UsersHandler) rehashPassword(ctx context.Context, userData *models.UserData, password string) {
+	passwordHash, err := handler.Hasher.Hash(password)
+	if err != nil {
+		logger.Log.Info(err.Error())
+		return
+	}
+
+	err = handler.Storage.UpdatePasswordHash(ctx, userData.UserID, userData.Password, passwordHash)
+	if err != nil {
+		logger.Log.Info(err.Error())
+		return
+	}
+
+	logger.Log.Info(fmt.Sprintf("Password hash of user %s upgraded to current parameters", userData.UserID))
+}
+
+func registerLoginFailure(ctx context.Context, cfg *config.Config, attempts LoginAttemptsStorage, key string, maxAttempts int) {
+	failures, err := attempts.InsertLoginFailure(ctx, key, cfg.LoginAttemptsWindow)
+	if err != nil {
+		logger.Log.Info(err.Error())
+		return
+	}
+
+	lockout := loginLockout(failures, maxAttempts, cfg.LoginLockout, cfg.LoginLockoutMax)
+	if lockout == 0 {
+		return
+	}
+
+	logger.Log.Info(fmt.Sprintf("Too many failed logins for %s, locked for %s", key, lockout))
+	if err = attempts.UpdateLockedUntil(ctx, key, time.Now().Add(lockout)); err != nil {
+		logger.Log.Info(err.Error())
+	}
+}
+
+// loginLockout doubles the lockout for every failure past the limit.
+func loginLockout(failures int, maxAttempts int, base time.Duration, limit time.Duration) time.Duration {
+	if maxAttempts <= 0 || failures < maxAttempts {
+		return 0
+	}
+
+	lockout := base
+	for i := maxAttempts; i < failures && lockout < limit; i++ {
+		lockout *= 2
+	}
+
+	return min(lockout, limit)
+}
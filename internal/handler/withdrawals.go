@@ -22,7 +22,7 @@ type WithdrawalsHandler struct {
 	Storage WithdrawalsStorage
 }
 
-func NewWithdrawalsHandler(router *mux.Router, cfg *config.Config, storage WithdrawalsStorage) {
+func NewWithdrawalsHandler(router *mux.Router, cfg *config.Config, storage WithdrawalsStorage, authenticate middleware.Authenticator) {
 
 	handler := &WithdrawalsHandler{
 		Config:  cfg,
@@ -31,6 +31,7 @@ func NewWithdrawalsHandler(router *mux.Router, cfg *config.Config, storage Withd
 
 	middlewareStack := middleware.Chain(
 		middleware.RequestLogger,
+		authenticate(auth.ScopeWithdrawalsRead),
 	)
 
 	router.HandleFunc(`/api/user/withdrawals`, middlewareStack(handler.GetUserWithdrawals())).Methods("GET")
@@ -38,19 +39,13 @@ func NewWithdrawalsHandler(router *mux.Router, cfg *config.Config, storage Withd
 
 func (handler *WithdrawalsHandler) GetUserWithdrawals() http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
-		token, err := req.Cookie("token")
-
-		if err != nil {
+		claims, ok := auth.ClaimsFromContext(req.Context())
+		if !ok {
 			logger.Log.Info("User unauthorized")
-			res.WriteHeader(http.StatusUnauthorized)
+			http.Error(res, "User unauthorized", http.StatusUnauthorized)
 			return
 		}
-
-		userID, err := auth.GetUserID(token.Value, handler.Config.SecretKey)
-		if err != nil {
-			logger.Log.Info(err.Error())
-			http.Error(res, err.Error(), http.StatusBadRequest)
-		}
+		userID := claims.UserID
 
 		res.Header().Set("Content-Type", "application/json")
 		if data, err := handler.Storage.SelectUserWithdrawals(req.Context(), userID); len(data) > 0 {
@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/nu-kotov/gophermart/internal/auth"
+	"github.com/nu-kotov/gophermart/internal/logger"
+	"github.com/nu-kotov/gophermart/internal/models"
+)
+
+const (
+	bearerPrefix = "Bearer "
+	apiKeyHeader = "X-API-Key"
+)
+
+type APIKeysStorage interface {
+	SelectAPIKey(context.Context, string) (*models.APIKey, error)
+}
+
+// Authenticator builds the authentication middleware of a route. API keys are
+// accepted only on routes that name the scopes they require, and only when
+// the key holds all of them; without scopes the route is for user sessions.
+type Authenticator func(scopes ...string) Middleware
+
+func Authenticate(keys *auth.KeySet, revocations auth.Revocations, apiKeys APIKeysStorage, csrfProtection bool) Authenticator {
+	return func(scopes ...string) Middleware {
+		return func(h http.HandlerFunc) http.HandlerFunc {
+			return func(res http.ResponseWriter, req *http.Request) {
+				if apiKey := apiKeyFromRequest(req); apiKey != "" {
+					claims, ok := authenticateAPIKey(res, req, apiKeys, apiKey, scopes)
+					if !ok {
+						return
+					}
+					h.ServeHTTP(res, req.WithContext(auth.ContextWithClaims(req.Context(), claims)))
+					return
+				}
+
+				tokenString, fromCookie := tokenFromRequest(req)
+				if tokenString == "" {
+					logger.Log.Info("User unauthorized: no token")
+					http.Error(res, "User unauthorized", http.StatusUnauthorized)
+					return
+				}
+
+				if csrfProtection && fromCookie && !ValidCSRF(req) {
+					logger.Log.Info("CSRF token missing or invalid for " + req.URL.Path)
+					http.Error(res, "Invalid CSRF token", http.StatusForbidden)
+					return
+				}
+
+				claims, err := auth.ParseToken(req.Context(), tokenString, keys, revocations)
+				if err != nil {
+					logger.Log.Info("User unauthorized: " + err.Error())
+					http.Error(res, "User unauthorized", http.StatusUnauthorized)
+					return
+				}
+
+				h.ServeHTTP(res, req.WithContext(auth.ContextWithClaims(req.Context(), claims)))
+			}
+		}
+	}
+}
+
+func authenticateAPIKey(res http.ResponseWriter, req *http.Request, apiKeys APIKeysStorage, apiKey string, scopes []string) (*auth.Claims, bool) {
+	key, err := apiKeys.SelectAPIKey(req.Context(), auth.HashToken(apiKey))
+	if err != nil {
+		logger.Log.Info("API key rejected: " + err.Error())
+		http.Error(res, "User unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	claims := &auth.Claims{
+		UserID:   key.UserID,
+		Login:    key.Login,
+		APIKeyID: key.KeyID,
+		Scopes:   key.Scopes,
+	}
+
+	if len(scopes) == 0 || !allScopes(claims, scopes) {
+		logger.Log.Info("API key " + key.KeyID + " lacks scope for " + req.URL.Path)
+		http.Error(res, "Insufficient scope", http.StatusForbidden)
+		return nil, false
+	}
+
+	return claims, true
+}
+
+func allScopes(claims *auth.Claims, scopes []string) bool {
+	return !slices.ContainsFunc(scopes, func(scope string) bool {
+		return !claims.HasScope(scope)
+	})
+}
+
+func apiKeyFromRequest(req *http.Request) string {
+	if key := req.Header.Get(apiKeyHeader); key != "" {
+		return strings.TrimSpace(key)
+	}
+
+	if header := req.Header.Get("Authorization"); strings.HasPrefix(header, bearerPrefix+auth.APIKeyPrefix) {
+		return strings.TrimSpace(strings.TrimPrefix(header, bearerPrefix))
+	}
+
+	return ""
+}
+
+// tokenFromRequest also reports whether the token came from the cookie, the
+// only source a browser attaches on its own.
+func tokenFromRequest(req *http.Request) (string, bool) {
+	if header := req.Header.Get("Authorization"); strings.HasPrefix(header, bearerPrefix) {
+		return strings.TrimSpace(strings.TrimPrefix(header, bearerPrefix)), false
+	}
+
+	if cookie, err := req.Cookie("token"); err == nil {
+		return cookie.Value, true
+	}
+
+	return "", false
+}
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/nu-kotov/gophermart/internal/auth"
+	"github.com/nu-kotov/gophermart/internal/logger"
+)
+
+// RequireRoles lets the request through when the authenticated user holds at
+// least one of the roles. It must be chained after Authenticate.
+func RequireRoles(roles ...string) Middleware {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return func(res http.ResponseWriter, req *http.Request) {
+			claims, ok := auth.ClaimsFromContext(req.Context())
+			if !ok {
+				logger.Log.Info("User unauthorized")
+				http.Error(res, "User unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if !slices.ContainsFunc(roles, claims.HasRole) {
+				logger.Log.Info("Access denied for user " + claims.UserID)
+				http.Error(res, "Access denied", http.StatusForbidden)
+				return
+			}
+
+			h.ServeHTTP(res, req)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+type UserExport struct {
+	ExportedAt     time.Time               `json:"exported_at"`
+	Profile        UserProfile             `json:"profile"`
+	Identities     []UserIdentity          `json:"identities"`
+	Sessions       []Session               `json:"sessions"`
+	APIKeys        []APIKey                `json:"api_keys"`
+	Balance        UserBalance             `json:"balance"`
+	BalanceHistory []BalanceHistoryEntry   `json:"balance_history"`
+	Orders         []GetUserOrdersResponse `json:"orders"`
+	Withdrawals    []WithdrawnInfo         `json:"withdrawals"`
+}
+
+type BalanceHistoryEntry struct {
+	Type    string    `json:"type"`
+	Order   string    `json:"order,omitempty"`
+	Amount  Money     `json:"amount"`
+	Balance Money     `json:"balance"`
+	At      time.Time `json:"at"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
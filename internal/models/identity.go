@@ -0,0 +1,14 @@
+package models
+
+type UserIdentity struct {
+	Issuer  string `json:"issuer"`
+	Subject string `json:"subject"`
+	UserID  string `json:"-"`
+	Email   string `json:"email"`
+}
+
+type OIDCState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
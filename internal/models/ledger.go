@@ -0,0 +1,25 @@
+package models
+
+const (
+	LedgerAccrual    = "accrual"
+	LedgerWithdrawal = "withdrawal"
+	LedgerAdjustment = "adjustment"
+)
+
+// LedgerEntry is a movement of points on a user's account. The storage books
+// it against the counter account of its kind. A positive amount credits the
+// user. OrderNumber is set for accruals, WithdrawalNumber for withdrawals.
+type LedgerEntry struct {
+	UserID           string
+	Kind             string
+	Amount           Money
+	OrderNumber      int64
+	WithdrawalNumber int64
+	Reason           string
+	CreatedBy        string
+}
+
+type BalanceAdjustmentRequest struct {
+	Amount Money  `json:"amount"`
+	Reason string `json:"reason"`
+}
@@ -0,0 +1,129 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount of loyalty points in hundredths, the scale of the
+// DECIMAL(12, 2) columns. Amounts with more decimal places are rounded to the
+// nearest hundredth, halves away from zero.
+type Money int64
+
+var ErrInvalidMoney = errors.New("invalid money amount")
+
+var hundred = big.NewInt(100)
+
+// ParseMoney reads a decimal number exactly, without going through float64.
+func ParseMoney(s string) (Money, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+
+	num := new(big.Int).Mul(r.Num(), hundred)
+	quo, rem := new(big.Int).QuoRem(num, r.Denom(), new(big.Int))
+	if rem.Sign() != 0 && new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(r.Denom()) >= 0 {
+		quo.Add(quo, big.NewInt(int64(rem.Sign())))
+	}
+
+	if !quo.IsInt64() {
+		return 0, fmt.Errorf("%w: %q is out of range", ErrInvalidMoney, s)
+	}
+
+	return Money(quo.Int64()), nil
+}
+
+func (m Money) String() string {
+	sign := ""
+	cents := int64(m)
+	if cents < 0 {
+		sign = "-"
+	}
+
+	units, frac := cents/100, cents%100
+	if units < 0 {
+		units = -units
+	}
+	if frac < 0 {
+		frac = -frac
+	}
+
+	return fmt.Sprintf("%s%d.%02d", sign, units, frac)
+}
+
+// MarshalJSON writes a plain JSON number without trailing zeros, 500 and
+// 500.5 rather than 500.00 and 500.50.
+func (m Money) MarshalJSON() ([]byte, error) {
+	s := m.String()
+	s = strings.TrimRight(s, "0")
+	s = strings.TrimSuffix(s, ".")
+	if s == "" || s == "-" {
+		s = "0"
+	}
+
+	return []byte(s), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if strings.HasPrefix(s, `"`) {
+		return fmt.Errorf("%w: expected a number, got %s", ErrInvalidMoney, s)
+	}
+
+	value, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = value
+
+	return nil
+}
+
+// Scan reads a numeric column. NULL is read as zero, the meaning a missing
+// accrual or sum has everywhere in the service.
+func (m *Money) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*m = 0
+	case string:
+		return m.scanString(v)
+	case []byte:
+		return m.scanString(string(v))
+	case int64:
+		if v > math.MaxInt64/100 || v < math.MinInt64/100 {
+			return fmt.Errorf("%w: %d is out of range", ErrInvalidMoney, v)
+		}
+		*m = Money(v * 100)
+	case float64:
+		return m.scanString(strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		return fmt.Errorf("%w: cannot scan %T", ErrInvalidMoney, src)
+	}
+
+	return nil
+}
+
+func (m *Money) scanString(s string) error {
+	value, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = value
+
+	return nil
+}
+
+// Value passes the amount as decimal text, which Postgres converts to
+// numeric exactly.
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
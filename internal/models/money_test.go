@@ -0,0 +1,79 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+	}{
+		{"0", 0},
+		{"500", 50000},
+		{"729.98", 72998},
+		{"0.1", 10},
+		{"1e2", 10000},
+		{"0.125", 13},
+		{"0.124", 12},
+		{"-0.125", -13},
+		{"-0.005", -1},
+		{"0.004999", 0},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in)
+		if err != nil {
+			t.Errorf("ParseMoney(%q) error = %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseMoney(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "abc", "1e30"} {
+		if _, err := ParseMoney(in); err == nil {
+			t.Errorf("ParseMoney(%q) error = nil, want an error", in)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	tests := []struct {
+		money Money
+		json  string
+	}{
+		{0, "0"},
+		{50000, "500"},
+		{50050, "500.5"},
+		{72998, "729.98"},
+		{-5, "-0.05"},
+	}
+
+	for _, tt := range tests {
+		data, err := json.Marshal(tt.money)
+		if err != nil || string(data) != tt.json {
+			t.Errorf("Marshal(%d) = %s, %v, want %s", tt.money, data, err, tt.json)
+		}
+
+		var got Money
+		if err = json.Unmarshal([]byte(tt.json), &got); err != nil || got != tt.money {
+			t.Errorf("Unmarshal(%s) = %d, %v, want %d", tt.json, got, err, tt.money)
+		}
+	}
+
+	// Adding 0.1 ten times is exact, unlike with float64.
+	var sum Money
+	for range 10 {
+		var step Money
+		if err := json.Unmarshal([]byte("0.1"), &step); err != nil {
+			t.Fatal(err)
+		}
+		sum += step
+	}
+	if sum != 100 {
+		t.Errorf("sum = %s, want 1.00", sum)
+	}
+}
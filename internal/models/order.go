@@ -3,16 +3,63 @@ package models
 import "time"
 
 type OrderData struct {
-	Number     int64     `json:"number"`
-	UserID     string    `json:"user_id"`
-	Status     string    `json:"status"`
-	Accrual    float64   `json:"accrual"`
-	UploadedAt time.Time `json:"uploaded_at"`
+	Number     int64       `json:"number"`
+	UserID     string      `json:"user_id"`
+	Status     OrderStatus `json:"status"`
+	Accrual    Money       `json:"accrual"`
+	UploadedAt time.Time   `json:"uploaded_at"`
 }
 
 type GetUserOrdersResponse struct {
 	Number     string    `json:"number"`
 	Status     string    `json:"status"`
-	Accrual    float64   `json:"accrual"`
+	Accrual    Money     `json:"accrual"`
 	UploadedAt time.Time `json:"uploaded_at"`
 }
+
+type GetUserOrdersPage struct {
+	Orders     []GetUserOrdersResponse `json:"orders"`
+	NextCursor string                  `json:"next_cursor,omitempty"`
+}
+
+// OrdersQuery selects a page of a user's orders. After is the position of the
+// last order of the previous page.
+type OrdersQuery struct {
+	Statuses   []string
+	From       *time.Time
+	To         *time.Time
+	Descending bool
+	Limit      int
+	After      *OrdersCursor
+}
+
+type OrdersCursor struct {
+	UploadedAt time.Time
+	Number     int64
+}
+
+type GetUserOrderResponse struct {
+	GetUserOrdersResponse
+	StatusChangedAt time.Time `json:"status_changed_at"`
+}
+
+const (
+	BatchOrderAccepted  = "accepted"
+	BatchOrderDuplicate = "duplicate"
+	BatchOrderConflict  = "conflict"
+	BatchOrderInvalid   = "invalid"
+)
+
+type BatchOrderResult struct {
+	Number string `json:"number"`
+	Status string `json:"status"`
+}
+
+// OrderEvent is a status change of an order. The first event of an order has
+// no previous status.
+type OrderEvent struct {
+	FromStatus string    `json:"from_status,omitempty"`
+	Status     string    `json:"status"`
+	Accrual    Money     `json:"accrual,omitempty"`
+	At         time.Time `json:"at"`
+}
@@ -0,0 +1,54 @@
+package models
+
+import "slices"
+
+type OrderStatus string
+
+const (
+	OrderStatusNew        OrderStatus = "NEW"
+	OrderStatusRegistered OrderStatus = "REGISTERED"
+	OrderStatusProcessing OrderStatus = "PROCESSING"
+	OrderStatusInvalid    OrderStatus = "INVALID"
+	OrderStatusProcessed  OrderStatus = "PROCESSED"
+)
+
+// orderTransitions lists the statuses an order may move to. INVALID and
+// PROCESSED are final. Steps may be skipped, as the accrual system can report
+// a final status on the first poll.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderStatusNew:        {OrderStatusRegistered, OrderStatusProcessing, OrderStatusInvalid, OrderStatusProcessed},
+	OrderStatusRegistered: {OrderStatusProcessing, OrderStatusInvalid, OrderStatusProcessed},
+	OrderStatusProcessing: {OrderStatusInvalid, OrderStatusProcessed},
+	OrderStatusInvalid:    {},
+	OrderStatusProcessed:  {},
+}
+
+func OrderStatuses() []OrderStatus {
+	return []OrderStatus{OrderStatusNew, OrderStatusRegistered, OrderStatusProcessing, OrderStatusInvalid, OrderStatusProcessed}
+}
+
+func (s OrderStatus) Valid() bool {
+	_, ok := orderTransitions[s]
+	return ok
+}
+
+func (s OrderStatus) Final() bool {
+	return s.Valid() && len(orderTransitions[s]) == 0
+}
+
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+	return slices.Contains(orderTransitions[s], next)
+}
+
+// OrderStatusesBefore returns the statuses an order may be in to be updated to
+// next. A non-final status may be repeated, which refreshes the accrual
+// without recording a transition.
+func OrderStatusesBefore(next OrderStatus) []string {
+	var statuses []string
+	for _, status := range OrderStatuses() {
+		if status.CanTransitionTo(next) || (status == next && !next.Final()) {
+			statuses = append(statuses, string(status))
+		}
+	}
+	return statuses
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+type UserProfile struct {
+	UserID           string                  `json:"user_id"`
+	Login            string                  `json:"login"`
+	DisplayName      string                  `json:"display_name"`
+	Roles            []string                `json:"roles"`
+	RegisteredAt     time.Time               `json:"registered_at"`
+	LastLoginAt      *time.Time              `json:"last_login_at,omitempty"`
+	TwoFactorEnabled bool                    `json:"two_factor_enabled"`
+	Notifications    NotificationPreferences `json:"notifications"`
+}
+
+type NotificationPreferences struct {
+	OrderUpdates   bool `json:"order_updates"`
+	SecurityEvents bool `json:"security_events"`
+}
+
+// UpdateProfileRequest holds the editable profile fields. Fields left out of
+// the request are nil and keep their value.
+type UpdateProfileRequest struct {
+	DisplayName   *string                        `json:"display_name"`
+	Notifications *UpdateNotificationPreferences `json:"notifications"`
+}
+
+type UpdateNotificationPreferences struct {
+	OrderUpdates   *bool `json:"order_updates"`
+	SecurityEvents *bool `json:"security_events"`
+}
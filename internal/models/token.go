@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+type RefreshToken struct {
+	TokenHash string
+	SessionID string
+	UserID    string
+	Login     string
+	Roles     []string
+	ExpiresAt time.Time
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type TokensResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
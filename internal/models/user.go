@@ -4,4 +4,11 @@ type UserData struct {
 	UserID   string `json:"user_id"`
 	Login    string `json:"login"`
 	Password string `json:"password"`
+
+	Roles            []string `json:"-"`
+	TwoFactorEnabled bool     `json:"-"`
+}
+
+type UserRolesRequest struct {
+	Roles []string `json:"roles"`
 }
@@ -3,14 +3,14 @@ package models
 import "time"
 
 type WithdrawnInfo struct {
-	Number      string  `json:"order"`
-	Sum         float64 `json:"sum"`
-	WithdrawnAt string  `json:"withdrawn_at"`
+	Number      string `json:"order"`
+	Sum         Money  `json:"sum"`
+	WithdrawnAt string `json:"withdrawn_at"`
 }
 
 type Withdraw struct {
 	Number      int64     `json:"order"`
 	UserID      string    `json:"user_id"`
-	Sum         float64   `json:"sum"`
+	Sum         Money     `json:"sum"`
 	WithdrawnAt time.Time `json:"withdrawn_at"`
 }
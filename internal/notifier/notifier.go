@@ -0,0 +1,83 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nu-kotov/gophermart/internal/config"
+	"github.com/nu-kotov/gophermart/internal/logger"
+	"go.uber.org/zap"
+)
+
+type Notifier interface {
+	NotifyPasswordReset(ctx context.Context, login string, token string) error
+}
+
+func NewNotifier(cfg *config.Config) (Notifier, error) {
+	switch cfg.Notifier {
+	case "", "log":
+		return &LogNotifier{}, nil
+	case "file":
+		if cfg.NotifierFile == "" {
+			return nil, fmt.Errorf("file notifier requires NOTIFIER_FILE")
+		}
+		return &FileNotifier{Path: cfg.NotifierFile}, nil
+	}
+
+	return nil, fmt.Errorf("unknown notifier %q", cfg.Notifier)
+}
+
+type LogNotifier struct{}
+
+func (n *LogNotifier) NotifyPasswordReset(ctx context.Context, login string, token string) error {
+	logger.Log.Info("password reset requested",
+		zap.String("login", login),
+		zap.String("token", token),
+	)
+	return nil
+}
+
+type FileNotifier struct {
+	Path string
+	mu   sync.Mutex
+}
+
+type fileMessage struct {
+	Kind   string    `json:"kind"`
+	Login  string    `json:"login"`
+	Token  string    `json:"token"`
+	SentAt time.Time `json:"sent_at"`
+}
+
+func (n *FileNotifier) NotifyPasswordReset(ctx context.Context, login string, token string) error {
+	return n.write(fileMessage{
+		Kind:   "password_reset",
+		Login:  login,
+		Token:  token,
+		SentAt: time.Now(),
+	})
+}
+
+func (n *FileNotifier) write(msg fileMessage) error {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	file, err := os.OpenFile(n.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+
+	return err
+}
@@ -0,0 +1,228 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/nu-kotov/gophermart/internal/auth"
+)
+
+const (
+	testClientID     = "gophermart"
+	testClientSecret = "secret"
+	testRedirectURL  = "http://localhost:8181/api/user/oidc/callback"
+	testCode         = "auth-code"
+)
+
+type mockIdP struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	signKey   *rsa.PrivateKey
+	challenge string
+	nonce     string
+	audience  string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &mockIdP{key: key, signKey: key, audience: testClientID}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(res http.ResponseWriter, req *http.Request) {
+		writeJSON(res, discovery{
+			Issuer:                idp.server.URL,
+			AuthorizationEndpoint: idp.server.URL + "/authorize",
+			TokenEndpoint:         idp.server.URL + "/token",
+			JWKSURI:               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(res http.ResponseWriter, req *http.Request) {
+		writeJSON(res, auth.JWKSet{Keys: []auth.JWK{{
+			Kty: "RSA",
+			Kid: "idp-key",
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", idp.token)
+
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+
+	return idp
+}
+
+func (idp *mockIdP) token(res http.ResponseWriter, req *http.Request) {
+	clientID, clientSecret, ok := req.BasicAuth()
+	if !ok || clientID != testClientID || clientSecret != testClientSecret {
+		http.Error(res, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+
+	if err := req.ParseForm(); err != nil {
+		http.Error(res, `{"error":"invalid_request"}`, http.StatusBadRequest)
+		return
+	}
+	if req.PostForm.Get("grant_type") != "authorization_code" ||
+		req.PostForm.Get("code") != testCode ||
+		req.PostForm.Get("redirect_uri") != testRedirectURL ||
+		CodeChallenge(req.PostForm.Get("code_verifier")) != idp.challenge {
+		http.Error(res, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                idp.server.URL,
+		"sub":                "subject-1",
+		"aud":                idp.audience,
+		"exp":                time.Now().Add(time.Minute).Unix(),
+		"iat":                time.Now().Unix(),
+		"nonce":              idp.nonce,
+		"email":              "alice@example.com",
+		"preferred_username": "alice",
+	})
+	token.Header["kid"] = "idp-key"
+
+	idToken, err := token.SignedString(idp.signKey)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(res, tokenResponse{IDToken: idToken, AccessToken: "access", TokenType: "Bearer"})
+}
+
+func writeJSON(res http.ResponseWriter, v any) {
+	res.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(res).Encode(v)
+}
+
+// authorize plays the user agent: it follows the authorization URL as the
+// IdP would and returns the verifier the client must present.
+func authorize(t *testing.T, idp *mockIdP, provider *Provider) (verifier string, nonce string) {
+	t.Helper()
+
+	verifier, err := NewVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce, err = NewVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authURL, err := provider.AuthCodeURL(context.Background(), "state", nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != testClientID || query.Get("state") != "state" {
+		t.Fatalf("unexpected authorization URL %s", authURL)
+	}
+
+	idp.challenge = query.Get("code_challenge")
+	idp.nonce = query.Get("nonce")
+
+	return verifier, nonce
+}
+
+func newTestProvider(idp *mockIdP) *Provider {
+	return NewProvider(Config{
+		Issuer:       idp.server.URL + "/",
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+		Scopes:       []string{"openid", "email"},
+	})
+}
+
+func TestExchange(t *testing.T) {
+	idp := newMockIdP(t)
+	provider := newTestProvider(idp)
+
+	verifier, nonce := authorize(t, idp, provider)
+
+	idToken, err := provider.Exchange(context.Background(), testCode, verifier, nonce)
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+	if idToken.Subject != "subject-1" || idToken.Email != "alice@example.com" || idToken.PreferredUsername != "alice" {
+		t.Errorf("Exchange() = %+v", idToken)
+	}
+}
+
+func TestExchangeRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(idp *mockIdP, verifier *string, nonce *string)
+	}{
+		{
+			name:    "wrong code verifier",
+			prepare: func(idp *mockIdP, verifier *string, nonce *string) { *verifier = "wrong" },
+		},
+		{
+			name:    "foreign audience",
+			prepare: func(idp *mockIdP, verifier *string, nonce *string) { idp.audience = "another-client" },
+		},
+		{
+			name: "signed with another key",
+			prepare: func(idp *mockIdP, verifier *string, nonce *string) {
+				key, err := rsa.GenerateKey(rand.Reader, 2048)
+				if err != nil {
+					panic(err)
+				}
+				idp.signKey = key
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newMockIdP(t)
+			provider := newTestProvider(idp)
+
+			verifier, nonce := authorize(t, idp, provider)
+			tt.prepare(idp, &verifier, &nonce)
+
+			if _, err := provider.Exchange(context.Background(), testCode, verifier, nonce); err == nil {
+				t.Fatal("Exchange() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestExchangeNonceMismatchError(t *testing.T) {
+	idp := newMockIdP(t)
+	provider := newTestProvider(idp)
+
+	verifier, _ := authorize(t, idp, provider)
+
+	_, err := provider.Exchange(context.Background(), testCode, verifier, "other")
+	if !errors.Is(err, ErrNonceMismatch) {
+		t.Fatalf("Exchange() error = %v, want %v", err, ErrNonceMismatch)
+	}
+}
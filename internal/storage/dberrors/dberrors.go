@@ -10,3 +10,10 @@ var ErrUserOrderDuplicate = errors.New("current user data conflict")
 var ErrOrderDuplicate = errors.New("data conflict")
 var ErrNotFound = errors.New("data not found")
 var ErrUserNoBalance = errors.New("user have not balance")
+var ErrTokenExpired = errors.New("token expired")
+var ErrTokenReused = errors.New("token has already been used")
+var ErrLoginTaken = errors.New("login is already taken")
+var ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+var ErrInvalidTransition = errors.New("invalid order status transition")
+var ErrInsufficientFunds = errors.New("insufficient funds")
+var ErrRoleHeld = errors.New("role is already held by a user")
@@ -30,3 +30,15 @@ func NewUsersStorage(pg *postgres.DBStorage) *postgres.UsersStorage {
 func NewWithdrawalsStorage(pg *postgres.DBStorage) *postgres.WithdrawalsStorage {
 	return &postgres.WithdrawalsStorage{Stor: pg}
 }
+
+func NewTokensStorage(pg *postgres.DBStorage) *postgres.TokensStorage {
+	return &postgres.TokensStorage{Stor: pg}
+}
+
+func NewLoginAttemptsStorage(pg *postgres.DBStorage) *postgres.LoginAttemptsStorage {
+	return &postgres.LoginAttemptsStorage{Stor: pg}
+}
+
+func NewAPIKeysStorage(pg *postgres.DBStorage) *postgres.APIKeysStorage {
+	return &postgres.APIKeysStorage{Stor: pg}
+}
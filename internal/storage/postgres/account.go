@@ -0,0 +1,280 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/nu-kotov/gophermart/internal/models"
+	"github.com/nu-kotov/gophermart/internal/storage/dberrors"
+)
+
+// SelectUserExport collects everything stored about the user. The queries run
+// in one read-only snapshot so the archive is consistent.
+func (usrs *UsersStorage) SelectUserExport(ctx context.Context, userID string) (*models.UserExport, error) {
+
+	selectIdentities := `SELECT issuer, subject, email FROM user_identities WHERE user_id = $1 ORDER BY created_at`
+	selectSessions := `
+	    SELECT session_id, user_agent, ip, created_at, last_seen_at, expires_at, ended_at FROM sessions
+	    WHERE user_id = $1 ORDER BY created_at
+	`
+	selectAPIKeys := `
+	    SELECT key_id, name, prefix, scopes, created_at, last_used_at, expires_at FROM api_keys
+	    WHERE user_id = $1 ORDER BY created_at
+	`
+	selectBalance := `SELECT balance, withdrawn FROM users_balances WHERE user_id = $1`
+	selectHistory := `
+	    SELECT kind, COALESCE(order_number, withdrawal_number, 0), amount,
+	        SUM(amount) OVER (ORDER BY created_at, entry_id), created_at
+	    FROM ledger_entries
+	    WHERE account = 'user' AND user_id = $1
+	    ORDER BY created_at, entry_id
+	`
+	selectOrders := `SELECT number, status, COALESCE(accrual, 0), uploaded_at FROM orders WHERE user_id = $1 ORDER BY uploaded_at`
+	selectWithdrawals := `SELECT number, COALESCE(sum, 0), withdrawn_at FROM withdrawals WHERE user_id = $1 ORDER BY withdrawn_at`
+
+	tx, err := usrs.Stor.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	export := models.UserExport{
+		ExportedAt:     time.Now().UTC(),
+		Identities:     []models.UserIdentity{},
+		Sessions:       []models.Session{},
+		APIKeys:        []models.APIKey{},
+		BalanceHistory: []models.BalanceHistoryEntry{},
+		Orders:         []models.GetUserOrdersResponse{},
+		Withdrawals:    []models.WithdrawnInfo{},
+	}
+
+	profile, err := selectUserProfile(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	export.Profile = *profile
+
+	err = tx.QueryRowContext(ctx, selectBalance, userID).Scan(&export.Balance.Current, &export.Balance.Withdrawn)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	err = queryRows(ctx, tx, selectIdentities, userID, func(rows *sql.Rows) error {
+		identity := models.UserIdentity{UserID: userID}
+		if err := rows.Scan(&identity.Issuer, &identity.Subject, &identity.Email); err != nil {
+			return err
+		}
+		export.Identities = append(export.Identities, identity)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(ctx, tx, selectSessions, userID, func(rows *sql.Rows) error {
+		session := models.Session{UserID: userID}
+		err := rows.Scan(
+			&session.SessionID,
+			&session.UserAgent,
+			&session.IP,
+			&session.CreatedAt,
+			&session.LastSeenAt,
+			&session.ExpiresAt,
+			&session.EndedAt,
+		)
+		if err != nil {
+			return err
+		}
+		export.Sessions = append(export.Sessions, session)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(ctx, tx, selectAPIKeys, userID, func(rows *sql.Rows) error {
+		key := models.APIKey{UserID: userID}
+		err := rows.Scan(
+			&key.KeyID,
+			&key.Name,
+			&key.Prefix,
+			arrayScanner(&key.Scopes),
+			&key.CreatedAt,
+			&key.LastUsedAt,
+			&key.ExpiresAt,
+		)
+		if err != nil {
+			return err
+		}
+		export.APIKeys = append(export.APIKeys, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(ctx, tx, selectHistory, userID, func(rows *sql.Rows) error {
+		var entry models.BalanceHistoryEntry
+		var number int64
+		if err := rows.Scan(&entry.Type, &number, &entry.Amount, &entry.Balance, &entry.At); err != nil {
+			return err
+		}
+		if number != 0 {
+			entry.Order = strconv.FormatInt(number, 10)
+		}
+		export.BalanceHistory = append(export.BalanceHistory, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(ctx, tx, selectOrders, userID, func(rows *sql.Rows) error {
+		var order models.GetUserOrdersResponse
+		var number int64
+		if err := rows.Scan(&number, &order.Status, &order.Accrual, &order.UploadedAt); err != nil {
+			return err
+		}
+		order.Number = strconv.FormatInt(number, 10)
+		export.Orders = append(export.Orders, order)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(ctx, tx, selectWithdrawals, userID, func(rows *sql.Rows) error {
+		var withdrawal models.WithdrawnInfo
+		var number int64
+		var withdrawnAt time.Time
+		if err := rows.Scan(&number, &withdrawal.Sum, &withdrawnAt); err != nil {
+			return err
+		}
+		withdrawal.Number = strconv.FormatInt(number, 10)
+		withdrawal.WithdrawnAt = withdrawnAt.Format(time.RFC1123)
+		export.Withdrawals = append(export.Withdrawals, withdrawal)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &export, nil
+}
+
+// DeleteUser removes the account and everything used to authenticate it.
+// Orders, withdrawals and the balance are financial records and are kept,
+// moved to a random pseudonym that is not stored anywhere else.
+func (usrs *UsersStorage) DeleteUser(ctx context.Context, userID string) error {
+
+	deleteUser := `DELETE FROM users WHERE user_id = $1 RETURNING login`
+	pseudonymize := []string{
+		`UPDATE orders SET user_id = $2 WHERE user_id = $1`,
+		`UPDATE withdrawals SET user_id = $2 WHERE user_id = $1`,
+		`UPDATE users_balances SET user_id = $2 WHERE user_id = $1`,
+		`UPDATE order_credits SET user_id = $2 WHERE user_id = $1`,
+		`UPDATE ledger_entries SET user_id = $2 WHERE user_id = $1`,
+		`UPDATE ledger_entries SET created_by = $2 WHERE created_by = $1`,
+	}
+	deleteRelated := []string{
+		`DELETE FROM refresh_tokens WHERE user_id = $1`,
+		`DELETE FROM sessions WHERE user_id = $1`,
+		`DELETE FROM password_reset_tokens WHERE user_id = $1`,
+		`DELETE FROM recovery_codes WHERE user_id = $1`,
+		`DELETE FROM api_keys WHERE user_id = $1`,
+		`DELETE FROM user_identities WHERE user_id = $1`,
+	}
+	deleteLoginAttempts := `DELETE FROM login_attempts WHERE key = ANY($1)`
+
+	tx, err := usrs.Stor.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var login string
+	err = tx.QueryRowContext(ctx, deleteUser, userID).Scan(&login)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return dberrors.ErrNotFound
+		}
+		return err
+	}
+
+	pseudonym := uuid.New().String()
+	for _, query := range pseudonymize {
+		if _, err = tx.ExecContext(ctx, query, userID, pseudonym); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	for _, query := range deleteRelated {
+		if _, err = tx.ExecContext(ctx, query, userID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	attemptKeys := []string{"login:" + strings.ToLower(login), "2fa:" + userID}
+	if _, err = tx.ExecContext(ctx, deleteLoginAttempts, attemptKeys); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func queryRows(ctx context.Context, tx *sql.Tx, query string, userID string, scan func(*sql.Rows) error) error {
+	rows, err := tx.QueryContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err = scan(rows); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (usrs *UsersStorage) HasLinkedIdentity(ctx context.Context, userID string) (bool, error) {
+	var linked bool
+
+	query := `SELECT EXISTS (SELECT 1 FROM user_identities WHERE user_id = $1)`
+
+	err := usrs.Stor.db.QueryRowContext(ctx, query, userID).Scan(&linked)
+	if err != nil {
+		return false, err
+	}
+
+	return linked, nil
+}
+
+// SelectSessionCreatedAt returns when the session was started by a login.
+// Refreshing tokens keeps the session, so this is the time of the last
+// actual authentication on it.
+func (usrs *UsersStorage) SelectSessionCreatedAt(ctx context.Context, userID string, sessionID string) (time.Time, error) {
+	var createdAt time.Time
+
+	query := `SELECT created_at FROM sessions WHERE session_id = $1 AND user_id = $2 AND ended_at IS NULL`
+
+	err := usrs.Stor.db.QueryRowContext(ctx, query, sessionID, userID).Scan(&createdAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, dberrors.ErrNotFound
+		}
+		return time.Time{}, err
+	}
+
+	return createdAt, nil
+}
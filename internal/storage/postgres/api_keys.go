@@ -0,0 +1,140 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/nu-kotov/gophermart/internal/models"
+	"github.com/nu-kotov/gophermart/internal/storage/dberrors"
+)
+
+type APIKeysStorage struct {
+	Stor *DBStorage
+}
+
+func (aks *APIKeysStorage) InsertAPIKey(ctx context.Context, key *models.APIKey) error {
+
+	query := `
+	    INSERT INTO api_keys (key_id, user_id, name, prefix, key_hash, scopes, created_at, expires_at)
+	    VALUES ($1, $2, $3, $4, $5, $6, $7, $8);
+	`
+
+	_, err := aks.Stor.db.ExecContext(
+		ctx,
+		query,
+		key.KeyID,
+		key.UserID,
+		key.Name,
+		key.Prefix,
+		key.KeyHash,
+		key.Scopes,
+		key.CreatedAt,
+		key.ExpiresAt,
+	)
+
+	return err
+}
+
+func (aks *APIKeysStorage) SelectUserAPIKeys(ctx context.Context, userID string) ([]models.APIKey, error) {
+	var data []models.APIKey
+
+	query := `
+	    SELECT key_id, name, prefix, scopes, created_at, last_used_at, expires_at FROM api_keys
+	    WHERE user_id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now())
+	    ORDER BY created_at DESC
+	`
+
+	rows, err := aks.Stor.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		key := models.APIKey{UserID: userID}
+
+		err := rows.Scan(
+			&key.KeyID,
+			&key.Name,
+			&key.Prefix,
+			arrayScanner(&key.Scopes),
+			&key.CreatedAt,
+			&key.LastUsedAt,
+			&key.ExpiresAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		data = append(data, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (aks *APIKeysStorage) RevokeAPIKey(ctx context.Context, userID string, keyID string) error {
+
+	query := `UPDATE api_keys SET revoked_at = now() WHERE key_id = $1 AND user_id = $2 AND revoked_at IS NULL`
+
+	result, err := aks.Stor.db.ExecContext(ctx, query, keyID, userID)
+	if err != nil {
+		return err
+	}
+
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if revoked == 0 {
+		return dberrors.ErrNotFound
+	}
+
+	return nil
+}
+
+// SelectAPIKey finds a usable key by its hash and records that it was used.
+// The usage timestamp is written at most once a minute per key.
+func (aks *APIKeysStorage) SelectAPIKey(ctx context.Context, keyHash string) (*models.APIKey, error) {
+
+	var key models.APIKey
+
+	query := `
+	    SELECT k.key_id, k.user_id, u.login, k.name, k.prefix, k.scopes, k.created_at, k.last_used_at, k.expires_at
+	    FROM api_keys k
+	    JOIN users u ON u.user_id = k.user_id
+	    WHERE k.key_hash = $1 AND k.revoked_at IS NULL AND (k.expires_at IS NULL OR k.expires_at > now())
+	`
+	touchKey := `
+	    UPDATE api_keys SET last_used_at = now()
+	    WHERE key_id = $1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')
+	`
+
+	err := aks.Stor.db.QueryRowContext(ctx, query, keyHash).Scan(
+		&key.KeyID,
+		&key.UserID,
+		&key.Login,
+		&key.Name,
+		&key.Prefix,
+		arrayScanner(&key.Scopes),
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.ExpiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, dberrors.ErrNotFound
+		}
+		return nil, err
+	}
+
+	if _, err = aks.Stor.db.ExecContext(ctx, touchKey, key.KeyID); err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"errors"
 
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/nu-kotov/gophermart/internal/models"
 	"github.com/nu-kotov/gophermart/internal/storage/dberrors"
@@ -39,9 +41,12 @@ func (bs *BalanceStorage) SelectUserBalance(ctx context.Context, userID string)
 	return &userBalance, nil
 }
 
-func (bs *BalanceStorage) UpdateUserBalance(ctx context.Context, newBalance *models.UserBalance, withdraw *models.Withdraw) error {
+// Withdraw records the withdrawal and debits it through the ledger in one
+// transaction. The balance projection rejects a debit beyond the balance
+// while holding the balance row, so concurrent withdrawals can not both pass
+// the funds check.
+func (bs *BalanceStorage) Withdraw(ctx context.Context, withdraw *models.Withdraw) error {
 
-	updateUsersBalances := `UPDATE users_balances SET balance=$1, withdrawn=$2 WHERE user_id = $3`
 	insertWithdrawal := `INSERT INTO withdrawals (number, user_id, sum, withdrawn_at) VALUES ($1, $2, $3, $4);`
 
 	tx, err := bs.Stor.db.Begin()
@@ -51,26 +56,30 @@ func (bs *BalanceStorage) UpdateUserBalance(ctx context.Context, newBalance *mod
 
 	_, err = tx.ExecContext(
 		ctx,
-		updateUsersBalances,
-		newBalance.Current,
-		newBalance.Withdrawn,
+		insertWithdrawal,
+		withdraw.Number,
 		withdraw.UserID,
+		withdraw.Sum,
+		withdraw.WithdrawnAt,
 	)
 
 	if err != nil {
 		tx.Rollback()
+
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return dberrors.ErrOrderDuplicate
+		}
+
 		return err
 	}
 
-	_, err = tx.ExecContext(
-		ctx,
-		insertWithdrawal,
-		withdraw.Number,
-		withdraw.UserID,
-		withdraw.Sum,
-		withdraw.WithdrawnAt,
-	)
-
+	err = insertLedgerEntry(ctx, tx, &models.LedgerEntry{
+		UserID:           withdraw.UserID,
+		Kind:             models.LedgerWithdrawal,
+		Amount:           -withdraw.Sum,
+		WithdrawalNumber: withdraw.Number,
+	})
 	if err != nil {
 		tx.Rollback()
 		return err
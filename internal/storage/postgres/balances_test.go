@@ -0,0 +1,70 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nu-kotov/gophermart/internal/models"
+	"github.com/nu-kotov/gophermart/internal/storage/dberrors"
+)
+
+func TestWithdrawConcurrentOverspend(t *testing.T) {
+	ctx := context.Background()
+	stor := newTestDBStorage(t)
+	bs := &BalanceStorage{Stor: stor}
+
+	user := models.UserData{UserID: uuid.New().String(), Login: "test-" + uuid.New().String()[:8], Password: "-"}
+	if err := (&UsersStorage{Stor: stor}).InsertUserData(ctx, &user); err != nil {
+		t.Fatal(err)
+	}
+	userID := user.UserID
+
+	err := bs.AdjustBalance(ctx, &models.LedgerEntry{UserID: userID, Amount: 10000, Reason: "test funds"})
+	if err != nil {
+		t.Fatalf("AdjustBalance() error = %v", err)
+	}
+
+	// Ten withdrawals of 30.00 race for a balance of 100: three may pass.
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- bs.Withdraw(ctx, &models.Withdraw{
+				Number:      rand.Int64N(1 << 50),
+				UserID:      userID,
+				Sum:         3000,
+				WithdrawnAt: time.Now(),
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	withdrawals := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			withdrawals++
+		case !errors.Is(err, dberrors.ErrInsufficientFunds):
+			t.Fatalf("Withdraw() error = %v", err)
+		}
+	}
+
+	balance, err := bs.SelectUserBalance(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if withdrawals != 3 || balance.Current != 1000 || balance.Withdrawn != 9000 {
+		t.Errorf("withdrawals = %d, balance = %s and %s, want 3, 10.00 and 90.00", withdrawals, balance.Current, balance.Withdrawn)
+	}
+	if ledger := ledgerBalance(t, stor, userID); ledger != balance.Current {
+		t.Errorf("ledger = %s, want the projected balance %s", ledger, balance.Current)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"embed"
 
+	"github.com/jackc/pgx/v5/pgtype"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/pressly/goose/v3"
 )
@@ -42,3 +43,9 @@ func NewConnect(connString string) (*DBStorage, error) {
 func (pg *DBStorage) Close() error {
 	return pg.db.Close()
 }
+
+// arrayScanner scans a Postgres array into a Go slice. The type map memoizes
+// scan plans and is not safe for concurrent use, so each scan gets its own.
+func arrayScanner(dest any) sql.Scanner {
+	return pgtype.NewMap().SQLScanner(dest)
+}
@@ -0,0 +1,95 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/nu-kotov/gophermart/internal/models"
+	"github.com/nu-kotov/gophermart/internal/storage/dberrors"
+)
+
+const ledgerUserAccount = "user"
+
+var ledgerCounterAccounts = map[string]string{
+	models.LedgerAccrual:    "accruals",
+	models.LedgerWithdrawal: "withdrawals",
+	models.LedgerAdjustment: "adjustments",
+}
+
+// insertLedgerEntry books the entry as a balanced transaction: the user's
+// account and the counter account of the kind. The balance projection is
+// updated by a trigger in the same transaction, so a debit beyond the balance
+// fails with ErrInsufficientFunds.
+func insertLedgerEntry(ctx context.Context, tx *sql.Tx, entry *models.LedgerEntry) error {
+
+	query := `
+	    INSERT INTO ledger_entries
+	        (transaction_id, account, user_id, kind, amount, order_number, withdrawal_number, reason, created_by)
+	    VALUES
+	        ($1, $2, $3, $4, $5, NULLIF($6::BIGINT, 0), NULLIF($7::BIGINT, 0), $8, NULLIF($9, '')::UUID),
+	        ($1, $10, NULL, $4, -$5::DECIMAL, NULLIF($6, 0), NULLIF($7, 0), $8, NULLIF($9, '')::UUID)
+	`
+
+	counterAccount, ok := ledgerCounterAccounts[entry.Kind]
+	if !ok {
+		return fmt.Errorf("unknown ledger entry kind %q", entry.Kind)
+	}
+
+	_, err := tx.ExecContext(
+		ctx,
+		query,
+		uuid.New().String(),
+		ledgerUserAccount,
+		entry.UserID,
+		entry.Kind,
+		entry.Amount,
+		entry.OrderNumber,
+		entry.WithdrawalNumber,
+		entry.Reason,
+		entry.CreatedBy,
+		counterAccount,
+	)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.CheckViolation && pgErr.ConstraintName == "users_balances_balance_check" {
+		return dberrors.ErrInsufficientFunds
+	}
+
+	return err
+}
+
+// AdjustBalance books a manual correction. Like any debit it can not take the
+// balance below zero.
+func (bs *BalanceStorage) AdjustBalance(ctx context.Context, entry *models.LedgerEntry) error {
+
+	selectUser := `SELECT 1 FROM users WHERE user_id = $1 FOR SHARE`
+
+	tx, err := bs.Stor.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var exists int
+	err = tx.QueryRowContext(ctx, selectUser, entry.UserID).Scan(&exists)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return dberrors.ErrNotFound
+		}
+		return err
+	}
+
+	entry.Kind = models.LedgerAdjustment
+	if err = insertLedgerEntry(ctx, tx, entry); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
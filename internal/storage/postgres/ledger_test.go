@@ -0,0 +1,106 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nu-kotov/gophermart/internal/models"
+)
+
+func ledgerBalance(t *testing.T, stor *DBStorage, userID string) models.Money {
+	t.Helper()
+
+	var balance models.Money
+	err := stor.db.QueryRow(
+		`SELECT SUM(amount) FROM ledger_entries WHERE account = 'user' AND user_id = $1`,
+		userID,
+	).Scan(&balance)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return balance
+}
+
+func TestLedgerProjectsBalance(t *testing.T) {
+	ctx := context.Background()
+	ords, order := newTestOrdersStorage(t)
+	bs := &BalanceStorage{Stor: ords.Stor}
+
+	if err := ords.UpdateOrder(ctx, processed(order, 5000)); err != nil {
+		t.Fatalf("UpdateOrder() error = %v", err)
+	}
+	err := bs.Withdraw(ctx, &models.Withdraw{
+		Number:      order.Number + 1,
+		UserID:      order.UserID,
+		Sum:         2050,
+		WithdrawnAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("Withdraw() error = %v", err)
+	}
+
+	ledger := ledgerBalance(t, ords.Stor, order.UserID)
+
+	balance, err := bs.SelectUserBalance(ctx, order.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Current != 2950 || balance.Withdrawn != 2050 || ledger != balance.Current {
+		t.Errorf("balance = %+v, ledger = %s, want 29.50 withdrawn 20.50 on both", *balance, ledger)
+	}
+}
+
+func TestLedgerEntriesImmutable(t *testing.T) {
+	ctx := context.Background()
+	ords, order := newTestOrdersStorage(t)
+
+	if err := ords.UpdateOrder(ctx, processed(order, 1000)); err != nil {
+		t.Fatalf("UpdateOrder() error = %v", err)
+	}
+
+	for _, query := range []string{
+		`UPDATE ledger_entries SET amount = amount * 2 WHERE order_number = $1`,
+		`DELETE FROM ledger_entries WHERE order_number = $1`,
+	} {
+		if _, err := ords.Stor.db.Exec(query, order.Number); err == nil {
+			t.Errorf("%q succeeded, want ledger entries to be immutable", query)
+		}
+	}
+}
+
+func TestDeleteUserPseudonymizesLedgerAuthor(t *testing.T) {
+	ctx := context.Background()
+	stor := newTestDBStorage(t)
+	usrs := &UsersStorage{Stor: stor}
+	bs := &BalanceStorage{Stor: stor}
+
+	var users [2]models.UserData
+	for i := range users {
+		users[i] = models.UserData{UserID: uuid.New().String(), Login: "test-" + uuid.New().String()[:8], Password: "-"}
+		if err := usrs.InsertUserData(ctx, &users[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	admin, user := users[0], users[1]
+
+	err := bs.AdjustBalance(ctx, &models.LedgerEntry{UserID: user.UserID, Amount: 500, Reason: "goodwill", CreatedBy: admin.UserID})
+	if err != nil {
+		t.Fatalf("AdjustBalance() error = %v", err)
+	}
+
+	if err = usrs.DeleteUser(ctx, admin.UserID); err != nil {
+		t.Fatalf("DeleteUser() error = %v", err)
+	}
+
+	var authored int
+	err = stor.db.QueryRow(`SELECT count(*) FROM ledger_entries WHERE created_by = $1`, admin.UserID).Scan(&authored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authored != 0 {
+		t.Errorf("ledger entries authored by the deleted user = %d, want 0", authored)
+	}
+}
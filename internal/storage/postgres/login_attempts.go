@@ -0,0 +1,68 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+type LoginAttemptsStorage struct {
+	Stor *DBStorage
+}
+
+func (las *LoginAttemptsStorage) SelectLockedUntil(ctx context.Context, keys []string) (time.Time, error) {
+
+	query := `SELECT max(locked_until) FROM login_attempts WHERE key = ANY($1) AND locked_until > now()`
+
+	var lockedUntil sql.NullTime
+	err := las.Stor.db.QueryRowContext(ctx, query, keys).Scan(&lockedUntil)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return lockedUntil.Time, nil
+}
+
+func (las *LoginAttemptsStorage) InsertLoginFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+
+	query := `
+	    INSERT INTO login_attempts (key, failures, last_failure_at) VALUES ($1, 1, now())
+	    ON CONFLICT (key) DO UPDATE SET
+	        failures = CASE
+	            WHEN login_attempts.last_failure_at < now() - make_interval(secs => $2)
+	                AND coalesce(login_attempts.locked_until, '-infinity') < now() - make_interval(secs => $2)
+	            THEN 1
+	            ELSE login_attempts.failures + 1
+	        END,
+	        last_failure_at = now()
+	    RETURNING failures
+	`
+
+	var failures int
+	err := las.Stor.db.QueryRowContext(ctx, query, key, window.Seconds()).Scan(&failures)
+	if err != nil {
+		return 0, err
+	}
+
+	return failures, nil
+}
+
+func (las *LoginAttemptsStorage) UpdateLockedUntil(ctx context.Context, key string, lockedUntil time.Time) error {
+
+	query := `UPDATE login_attempts SET locked_until = $2 WHERE key = $1`
+
+	_, err := las.Stor.db.ExecContext(ctx, query, key, lockedUntil)
+
+	return err
+}
+
+func (las *LoginAttemptsStorage) DeleteLoginFailures(ctx context.Context, key string) error {
+
+	query := `DELETE FROM login_attempts WHERE key = $1`
+
+	_, err := las.Stor.db.ExecContext(ctx, query, key)
+
+	return err
+}
@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS sessions (
+    session_id    UUID                     NOT NULL PRIMARY KEY,
+    user_id       UUID                     NOT NULL,
+    user_agent    TEXT                     NOT NULL DEFAULT '',
+    ip            TEXT                     NOT NULL DEFAULT '',
+    created_at    TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    last_seen_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    expires_at    TIMESTAMP WITH TIME ZONE NOT NULL,
+    ended_at      TIMESTAMP WITH TIME ZONE     NULL
+);
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
+
+ALTER TABLE refresh_tokens RENAME COLUMN family_id TO session_id;
+ALTER INDEX IF EXISTS refresh_tokens_family_id_idx RENAME TO refresh_tokens_session_id_idx;
+
+INSERT INTO sessions (session_id, user_id, created_at, last_seen_at, expires_at, ended_at)
+SELECT session_id, user_id, min(created_at), max(created_at), max(expires_at),
+       CASE WHEN bool_and(revoked_at IS NOT NULL) THEN max(revoked_at) END
+FROM refresh_tokens
+GROUP BY session_id, user_id
+ON CONFLICT (session_id) DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER INDEX IF EXISTS refresh_tokens_session_id_idx RENAME TO refresh_tokens_family_id_idx;
+ALTER TABLE refresh_tokens RENAME COLUMN session_id TO family_id;
+DROP TABLE IF EXISTS sessions;
+-- +goose StatementEnd
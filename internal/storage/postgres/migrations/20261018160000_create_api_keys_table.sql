@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS api_keys (
+    key_id        UUID                     NOT NULL PRIMARY KEY,
+    user_id       UUID                     NOT NULL,
+    name          TEXT                     NOT NULL,
+    prefix        TEXT                     NOT NULL,
+    key_hash      TEXT                     NOT NULL UNIQUE,
+    scopes        TEXT[]                   NOT NULL,
+    created_at    TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    last_used_at  TIMESTAMP WITH TIME ZONE     NULL,
+    expires_at    TIMESTAMP WITH TIME ZONE     NULL,
+    revoked_at    TIMESTAMP WITH TIME ZONE     NULL
+);
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS api_keys;
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS registered_at          TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    ADD COLUMN IF NOT EXISTS last_login_at          TIMESTAMP WITH TIME ZONE     NULL,
+    ADD COLUMN IF NOT EXISTS display_name           TEXT                     NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS notify_order_updates   BOOLEAN                  NOT NULL DEFAULT true,
+    ADD COLUMN IF NOT EXISTS notify_security_events BOOLEAN                  NOT NULL DEFAULT true;
+UPDATE users u SET last_login_at = s.last_login_at
+FROM (SELECT user_id, max(created_at) AS last_login_at FROM sessions GROUP BY user_id) s
+WHERE s.user_id = u.user_id;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users
+    DROP COLUMN IF EXISTS registered_at,
+    DROP COLUMN IF EXISTS last_login_at,
+    DROP COLUMN IF EXISTS display_name,
+    DROP COLUMN IF EXISTS notify_order_updates,
+    DROP COLUMN IF EXISTS notify_security_events;
+-- +goose StatementEnd
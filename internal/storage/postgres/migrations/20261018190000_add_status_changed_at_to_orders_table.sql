@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS status_changed_at TIMESTAMP WITH TIME ZONE NULL;
+UPDATE orders SET status_changed_at = uploaded_at WHERE status_changed_at IS NULL;
+ALTER TABLE orders
+    ALTER COLUMN status_changed_at SET DEFAULT now(),
+    ALTER COLUMN status_changed_at SET NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE orders DROP COLUMN IF EXISTS status_changed_at;
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS order_events (
+    event_id      BIGSERIAL                PRIMARY KEY,
+    number        BIGINT                   NOT NULL REFERENCES orders (number) ON DELETE CASCADE,
+    from_status   TEXT                         NULL,
+    to_status     TEXT                     NOT NULL,
+    accrual       DECIMAL(12, 2)               NULL,
+    created_at    TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS order_events_number_idx ON order_events (number, created_at);
+INSERT INTO order_events (number, from_status, to_status, created_at)
+SELECT number, NULL, 'NEW', uploaded_at FROM orders;
+INSERT INTO order_events (number, from_status, to_status, accrual, created_at)
+SELECT number, 'NEW', status, accrual, status_changed_at FROM orders WHERE status <> 'NEW';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS order_events;
+-- +goose StatementEnd
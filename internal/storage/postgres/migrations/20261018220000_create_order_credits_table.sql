@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS order_credits (
+    number        BIGINT                   NOT NULL PRIMARY KEY REFERENCES orders (number),
+    user_id       UUID                     NOT NULL,
+    amount        DECIMAL(12, 2)           NOT NULL,
+    created_at    TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+INSERT INTO order_credits (number, user_id, amount, created_at)
+SELECT number, user_id, accrual, status_changed_at FROM orders
+WHERE status = 'PROCESSED' AND accrual > 0
+ON CONFLICT (number) DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS order_credits;
+-- +goose StatementEnd
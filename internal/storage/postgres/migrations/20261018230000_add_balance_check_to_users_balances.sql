@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- NOT VALID: balances overdrawn by concurrent withdrawals before this check
+-- existed are left as they are, every new write is checked.
+ALTER TABLE users_balances ADD CONSTRAINT users_balances_balance_check CHECK (balance >= 0) NOT VALID;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users_balances DROP CONSTRAINT IF EXISTS users_balances_balance_check;
+-- +goose StatementEnd
@@ -0,0 +1,134 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Every movement of points is a ledger transaction of two or more entries
+-- that sum to zero: the user's account and a counter account. users_balances
+-- is a projection of the user entries maintained by a trigger.
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    entry_id          BIGSERIAL                PRIMARY KEY,
+    transaction_id    UUID                     NOT NULL,
+    account           TEXT                     NOT NULL,
+    user_id           UUID                         NULL,
+    kind              TEXT                     NOT NULL CHECK (kind IN ('accrual', 'withdrawal', 'adjustment')),
+    amount            DECIMAL(12, 2)           NOT NULL CHECK (amount <> 0),
+    order_number      BIGINT                       NULL REFERENCES orders (number),
+    withdrawal_number BIGINT                       NULL REFERENCES withdrawals (number),
+    reason            TEXT                     NOT NULL DEFAULT '',
+    created_by        UUID                         NULL,
+    created_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    CHECK ((account = 'user') = (user_id IS NOT NULL)),
+    CHECK (kind <> 'accrual' OR order_number IS NOT NULL),
+    CHECK (kind <> 'withdrawal' OR withdrawal_number IS NOT NULL)
+);
+CREATE INDEX IF NOT EXISTS ledger_entries_user_id_idx ON ledger_entries (user_id, created_at) WHERE account = 'user';
+CREATE INDEX IF NOT EXISTS ledger_entries_transaction_id_idx ON ledger_entries (transaction_id);
+CREATE UNIQUE INDEX IF NOT EXISTS ledger_entries_accrual_idx ON ledger_entries (order_number) WHERE account = 'user' AND kind = 'accrual';
+CREATE UNIQUE INDEX IF NOT EXISTS ledger_entries_withdrawal_idx ON ledger_entries (withdrawal_number) WHERE account = 'user' AND kind = 'withdrawal';
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+-- Backfill from the credit records and withdrawals, then an opening balance
+-- adjustment for whatever the history does not explain.
+WITH credits AS (
+    SELECT gen_random_uuid() AS transaction_id, number, user_id, amount, created_at FROM order_credits
+)
+INSERT INTO ledger_entries (transaction_id, account, user_id, kind, amount, order_number, created_at)
+SELECT transaction_id, 'user', user_id, 'accrual', amount, number, created_at FROM credits
+UNION ALL
+SELECT transaction_id, 'accruals', NULL, 'accrual', -amount, number, created_at FROM credits;
+
+WITH debits AS (
+    SELECT gen_random_uuid() AS transaction_id, number, user_id, sum, withdrawn_at FROM withdrawals WHERE sum > 0
+)
+INSERT INTO ledger_entries (transaction_id, account, user_id, kind, amount, withdrawal_number, created_at)
+SELECT transaction_id, 'user', user_id, 'withdrawal', -sum, number, withdrawn_at FROM debits
+UNION ALL
+SELECT transaction_id, 'withdrawals', NULL, 'withdrawal', sum, number, withdrawn_at FROM debits;
+
+WITH openings AS (
+    SELECT gen_random_uuid() AS transaction_id, b.user_id, b.balance - COALESCE(l.amount, 0) AS amount
+    FROM users_balances b
+    LEFT JOIN (SELECT user_id, SUM(amount) AS amount FROM ledger_entries WHERE account = 'user' GROUP BY user_id) l
+        ON l.user_id = b.user_id
+    WHERE b.balance <> COALESCE(l.amount, 0)
+)
+INSERT INTO ledger_entries (transaction_id, account, user_id, kind, amount, reason)
+SELECT transaction_id, 'user', user_id, 'adjustment', amount, 'opening balance' FROM openings
+UNION ALL
+SELECT transaction_id, 'adjustments', NULL, 'adjustment', -amount, 'opening balance' FROM openings;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION ledger_entries_immutable() RETURNS trigger AS $$
+BEGIN
+    -- Only the owner may change, when a deleted account is pseudonymized.
+    IF TG_OP = 'UPDATE' AND
+        (NEW.entry_id, NEW.transaction_id, NEW.account, NEW.kind, NEW.amount, NEW.order_number,
+         NEW.withdrawal_number, NEW.reason, NEW.created_by, NEW.created_at)
+        IS NOT DISTINCT FROM
+        (OLD.entry_id, OLD.transaction_id, OLD.account, OLD.kind, OLD.amount, OLD.order_number,
+         OLD.withdrawal_number, OLD.reason, OLD.created_by, OLD.created_at) THEN
+        RETURN NEW;
+    END IF;
+
+    RAISE EXCEPTION 'ledger entries are immutable';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION ledger_check_balanced() RETURNS trigger AS $$
+BEGIN
+    IF (SELECT SUM(amount) FROM ledger_entries WHERE transaction_id = NEW.transaction_id) <> 0 THEN
+        RAISE EXCEPTION 'ledger transaction % is not balanced', NEW.transaction_id;
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+-- The update runs first: an insert is checked against users_balances_balance_check
+-- before a conflict is detected, so a debit can not go through ON CONFLICT.
+CREATE OR REPLACE FUNCTION ledger_project_balance() RETURNS trigger AS $$
+DECLARE
+    withdrawn_delta DECIMAL(12, 2) := CASE WHEN NEW.kind = 'withdrawal' THEN -NEW.amount ELSE 0 END;
+BEGIN
+    IF NEW.account <> 'user' THEN
+        RETURN NULL;
+    END IF;
+
+    UPDATE users_balances
+    SET balance = users_balances.balance + NEW.amount, withdrawn = users_balances.withdrawn + withdrawn_delta
+    WHERE user_id = NEW.user_id;
+
+    IF NOT FOUND THEN
+        INSERT INTO users_balances (user_id, balance, withdrawn) VALUES (NEW.user_id, NEW.amount, withdrawn_delta)
+        ON CONFLICT (user_id) DO UPDATE
+            SET balance = users_balances.balance + EXCLUDED.balance, withdrawn = users_balances.withdrawn + EXCLUDED.withdrawn;
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER ledger_entries_immutable BEFORE UPDATE OR DELETE ON ledger_entries
+    FOR EACH ROW EXECUTE FUNCTION ledger_entries_immutable();
+CREATE TRIGGER ledger_entries_no_truncate BEFORE TRUNCATE ON ledger_entries
+    FOR EACH STATEMENT EXECUTE FUNCTION ledger_entries_immutable();
+CREATE CONSTRAINT TRIGGER ledger_entries_balanced AFTER INSERT ON ledger_entries
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION ledger_check_balanced();
+CREATE TRIGGER ledger_entries_project_balance AFTER INSERT ON ledger_entries
+    FOR EACH ROW EXECUTE FUNCTION ledger_project_balance();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS ledger_entries;
+DROP FUNCTION IF EXISTS ledger_project_balance();
+DROP FUNCTION IF EXISTS ledger_check_balanced();
+DROP FUNCTION IF EXISTS ledger_entries_immutable();
+-- +goose StatementEnd
@@ -0,0 +1,38 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION ledger_entries_immutable() RETURNS trigger AS $$
+BEGIN
+    -- Only the owner and the author may change, when a deleted account is
+    -- pseudonymized.
+    IF TG_OP = 'UPDATE' AND
+        (NEW.entry_id, NEW.transaction_id, NEW.account, NEW.kind, NEW.amount, NEW.order_number,
+         NEW.withdrawal_number, NEW.reason, NEW.created_at)
+        IS NOT DISTINCT FROM
+        (OLD.entry_id, OLD.transaction_id, OLD.account, OLD.kind, OLD.amount, OLD.order_number,
+         OLD.withdrawal_number, OLD.reason, OLD.created_at) THEN
+        RETURN NEW;
+    END IF;
+
+    RAISE EXCEPTION 'ledger entries are immutable';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION ledger_entries_immutable() RETURNS trigger AS $$
+BEGIN
+    -- Only the owner may change, when a deleted account is pseudonymized.
+    IF TG_OP = 'UPDATE' AND
+        (NEW.entry_id, NEW.transaction_id, NEW.account, NEW.kind, NEW.amount, NEW.order_number,
+         NEW.withdrawal_number, NEW.reason, NEW.created_by, NEW.created_at)
+        IS NOT DISTINCT FROM
+        (OLD.entry_id, OLD.transaction_id, OLD.account, OLD.kind, OLD.amount, OLD.order_number,
+         OLD.withdrawal_number, OLD.reason, OLD.created_by, OLD.created_at) THEN
+        RETURN NEW;
+    END IF;
+
+    RAISE EXCEPTION 'ledger entries are immutable';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Users created before registered_at existed got the migration time. Their
+-- earliest session or order is the closest known registration time.
+UPDATE users u SET registered_at = f.first_seen_at
+FROM (
+    SELECT user_id, min(seen_at) AS first_seen_at
+    FROM (
+        SELECT user_id, created_at AS seen_at FROM sessions
+        UNION ALL
+        SELECT user_id, uploaded_at AS seen_at FROM orders
+    ) seen
+    GROUP BY user_id
+) f
+WHERE f.user_id = u.user_id AND f.first_seen_at < u.registered_at;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- +goose StatementEnd
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 	"time"
@@ -21,7 +22,7 @@ type OrdersStorage struct {
 
 func (ords *OrdersStorage) InsertOrderData(ctx context.Context, data *models.OrderData) error {
 
-	sql := `INSERT INTO orders (number, user_id, status, accrual, uploaded_at) VALUES ($1, $2, $3, $4, $5);`
+	sql := `INSERT INTO orders (number, user_id, status, accrual, uploaded_at, status_changed_at) VALUES ($1, $2, $3, $4, $5, $5);`
 
 	tx, err := ords.Stor.db.Begin()
 	if err != nil {
@@ -33,7 +34,7 @@ func (ords *OrdersStorage) InsertOrderData(ctx context.Context, data *models.Ord
 		sql,
 		data.Number,
 		data.UserID,
-		data.Status,
+		string(data.Status),
 		data.Accrual,
 		data.UploadedAt,
 	)
@@ -53,9 +54,106 @@ func (ords *OrdersStorage) InsertOrderData(ctx context.Context, data *models.Ord
 		return err
 	}
 
+	if err = insertOrderEvent(ctx, tx, data.Number, "", string(data.Status), data.Accrual, data.UploadedAt); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	return tx.Commit()
 }
 
+// InsertOrdersBatch stores the numbers in one transaction and reports the
+// outcome per number. Numbers taken before are left untouched and classified
+// by their owner, so a single conflict does not abort the batch.
+func (ords *OrdersStorage) InsertOrdersBatch(ctx context.Context, userID string, numbers []int64, uploadedAt time.Time) (map[int64]string, error) {
+
+	insertOrders := `
+	    INSERT INTO orders (number, user_id, status, accrual, uploaded_at, status_changed_at)
+	    SELECT number, $2, 'NEW', 0, $3, $3 FROM unnest($1::BIGINT[]) AS number
+	    ON CONFLICT DO NOTHING
+	    RETURNING number
+	`
+	selectOwners := `SELECT number, user_id FROM orders WHERE number = ANY($1)`
+	insertEvents := `
+	    INSERT INTO order_events (number, to_status, created_at)
+	    SELECT number, 'NEW', $2 FROM unnest($1::BIGINT[]) AS number
+	`
+
+	tx, err := ords.Stor.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[int64]string, len(numbers))
+
+	rows, err := tx.QueryContext(ctx, insertOrders, numbers, userID, uploadedAt)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	for rows.Next() {
+		var number int64
+		if err = rows.Scan(&number); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return nil, err
+		}
+		results[number] = models.BatchOrderAccepted
+	}
+	if err = rows.Err(); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	var accepted, existing []int64
+	for _, number := range numbers {
+		if _, ok := results[number]; ok {
+			accepted = append(accepted, number)
+		} else {
+			existing = append(existing, number)
+		}
+	}
+
+	if len(accepted) > 0 {
+		if _, err = tx.ExecContext(ctx, insertEvents, accepted, uploadedAt); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if len(existing) > 0 {
+		rows, err = tx.QueryContext(ctx, selectOwners, existing)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		for rows.Next() {
+			var number int64
+			var ownerID string
+			if err = rows.Scan(&number, &ownerID); err != nil {
+				rows.Close()
+				tx.Rollback()
+				return nil, err
+			}
+			if ownerID == userID {
+				results[number] = models.BatchOrderDuplicate
+			} else {
+				results[number] = models.BatchOrderConflict
+			}
+		}
+		if err = rows.Err(); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
 func (ords *OrdersStorage) SelectOrdersByUserID(ctx context.Context, userID string) ([]models.GetUserOrdersResponse, error) {
 	var data []models.GetUserOrdersResponse
 
@@ -69,7 +167,7 @@ func (ords *OrdersStorage) SelectOrdersByUserID(ctx context.Context, userID stri
 
 	for rows.Next() {
 		var number int64
-		var accrual float64
+		var accrual models.Money
 		var status string
 		var uploadedAt time.Time
 
@@ -93,14 +191,110 @@ func (ords *OrdersStorage) SelectOrdersByUserID(ctx context.Context, userID stri
 	return data, nil
 }
 
+// SelectUserOrdersPage pages through the orders by (uploaded_at, number) with
+// a keyset condition, so the cost of a page does not grow with its position.
+func (ords *OrdersStorage) SelectUserOrdersPage(ctx context.Context, userID string, q *models.OrdersQuery) ([]models.GetUserOrdersResponse, error) {
+	conditions := []string{"user_id = $1"}
+	args := []any{userID}
+
+	arg := func(value any) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if len(q.Statuses) > 0 {
+		conditions = append(conditions, "status = ANY("+arg(q.Statuses)+")")
+	}
+	if q.From != nil {
+		conditions = append(conditions, "uploaded_at >= "+arg(*q.From))
+	}
+	if q.To != nil {
+		conditions = append(conditions, "uploaded_at < "+arg(*q.To))
+	}
+
+	direction, comparison := "ASC", ">"
+	if q.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if q.After != nil {
+		conditions = append(conditions, fmt.Sprintf("(uploaded_at, number) %s (%s, %s)", comparison, arg(q.After.UploadedAt), arg(q.After.Number)))
+	}
+
+	query := fmt.Sprintf(
+		`SELECT number, status, COALESCE(accrual, 0), uploaded_at FROM orders WHERE %s ORDER BY uploaded_at %s, number %s LIMIT %s`,
+		strings.Join(conditions, " AND "),
+		direction,
+		direction,
+		arg(q.Limit),
+	)
+
+	rows, err := ords.Stor.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	data := []models.GetUserOrdersResponse{}
+	for rows.Next() {
+		var order models.GetUserOrdersResponse
+		var number int64
+		if err := rows.Scan(&number, &order.Status, &order.Accrual, &order.UploadedAt); err != nil {
+			return nil, err
+		}
+		order.Number = strconv.FormatInt(number, 10)
+		data = append(data, order)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (ords *OrdersStorage) SelectUserOrder(ctx context.Context, userID string, number int64) (*models.GetUserOrderResponse, error) {
+
+	query := `
+	    SELECT status, COALESCE(accrual, 0), uploaded_at, status_changed_at FROM orders
+	    WHERE number = $1 AND user_id = $2
+	`
+
+	order := models.GetUserOrderResponse{}
+	order.Number = strconv.FormatInt(number, 10)
+
+	err := ords.Stor.db.QueryRowContext(ctx, query, number, userID).Scan(
+		&order.Status,
+		&order.Accrual,
+		&order.UploadedAt,
+		&order.StatusChangedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, dberrors.ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &order, nil
+}
+
+// UpdateOrder applies a status reported by the accrual system. The update
+// only matches while the stored status may move to the new one, so a late
+// response can not move a final order back; it fails with
+// ErrInvalidTransition. The accrual is credited once, when the order becomes
+// PROCESSED; the credit record of the order guards against a second credit.
 func (ords *OrdersStorage) UpdateOrder(ctx context.Context, pointsData *models.OrderData) error {
 
-	updateOrder := `UPDATE orders SET status=$1, accrual=$2 WHERE number=$3`
-	currentBalance := `SELECT balance FROM users_balances WHERE user_id=$1`
-	updateUsersBalances := `
-	    INSERT INTO users_balances (balance, user_id) VALUES ($1, $2) ON CONFLICT (user_id)
-	    DO UPDATE 
-		    SET balance=$1;
+	updateOrder := `
+	    UPDATE orders o
+	    SET status_changed_at = CASE WHEN o.status <> $1 THEN $5 ELSE o.status_changed_at END, status=$1, accrual=$2
+	    FROM (SELECT number, status FROM orders WHERE number=$3 FOR UPDATE) previous
+	    WHERE o.number = previous.number AND previous.status = ANY($4)
+	    RETURNING o.user_id, previous.status
+	`
+	currentStatus := `SELECT status FROM orders WHERE number=$1`
+	insertCredit := `
+	    INSERT INTO order_credits (number, user_id, amount) VALUES ($1, $2, $3)
+	    ON CONFLICT (number) DO NOTHING
 	`
 
 	tx, err := ords.Stor.db.Begin()
@@ -108,42 +302,63 @@ func (ords *OrdersStorage) UpdateOrder(ctx context.Context, pointsData *models.O
 		return err
 	}
 
-	_, err = tx.ExecContext(
+	// The owner is read back from the order: it may have been pseudonymized
+	// since the order was picked up for processing.
+	var previousStatus models.OrderStatus
+	changedAt := time.Now()
+	err = tx.QueryRowContext(
 		ctx,
 		updateOrder,
-		pointsData.Status,
+		string(pointsData.Status),
 		pointsData.Accrual,
 		pointsData.Number,
-	)
-
+		models.OrderStatusesBefore(pointsData.Status),
+		changedAt,
+	).Scan(&pointsData.UserID, &previousStatus)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		err = tx.QueryRowContext(ctx, currentStatus, pointsData.Number).Scan(&previousStatus)
+		if err == nil {
+			err = fmt.Errorf("%w: order %d from %s to %s", dberrors.ErrInvalidTransition, pointsData.Number, previousStatus, pointsData.Status)
+		}
+	}
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	row := ords.Stor.db.QueryRowContext(
-		ctx,
-		currentBalance,
-		pointsData.UserID,
-	)
-
-	var curBalance float64
-	err = row.Scan(&curBalance)
-	if err != nil {
-
-		if errors.Is(err, sql.ErrNoRows) {
-			curBalance = 0.0
-		} else {
+	if previousStatus != pointsData.Status {
+		err = insertOrderEvent(ctx, tx, pointsData.Number, string(previousStatus), string(pointsData.Status), pointsData.Accrual, changedAt)
+		if err != nil {
+			tx.Rollback()
 			return err
 		}
 	}
 
-	_, err = tx.ExecContext(
-		ctx,
-		updateUsersBalances,
-		curBalance+pointsData.Accrual,
-		pointsData.UserID,
-	)
+	if pointsData.Status != models.OrderStatusProcessed || pointsData.Accrual <= 0 {
+		return tx.Commit()
+	}
+
+	credit, err := tx.ExecContext(ctx, insertCredit, pointsData.Number, pointsData.UserID, pointsData.Accrual)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	credited, err := credit.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if credited == 0 {
+		return tx.Commit()
+	}
+
+	err = insertLedgerEntry(ctx, tx, &models.LedgerEntry{
+		UserID:      pointsData.UserID,
+		Kind:        models.LedgerAccrual,
+		Amount:      pointsData.Accrual,
+		OrderNumber: pointsData.Number,
+	})
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -166,7 +381,7 @@ func (ords *OrdersStorage) SelectUnprocessedOrders(ctx context.Context, limit in
 	for rows.Next() {
 		var number int64
 		var userID string
-		var accrual float64
+		var accrual models.Money
 		var status string
 
 		err := rows.Scan(&number, &userID, &status, &accrual)
@@ -177,7 +392,7 @@ func (ords *OrdersStorage) SelectUnprocessedOrders(ctx context.Context, limit in
 
 		unprocessedOrders = append(unprocessedOrders, models.OrderData{
 			Number:  number,
-			Status:  status,
+			Status:  models.OrderStatus(status),
 			Accrual: accrual,
 			UserID:  userID,
 		})
@@ -188,3 +403,62 @@ func (ords *OrdersStorage) SelectUnprocessedOrders(ctx context.Context, limit in
 
 	return unprocessedOrders, nil
 }
+
+// SelectOrderEvents returns the status timeline of the user's order, oldest
+// first.
+func (ords *OrdersStorage) SelectOrderEvents(ctx context.Context, userID string, number int64) ([]models.OrderEvent, error) {
+
+	query := `
+	    SELECT e.from_status, e.to_status, COALESCE(e.accrual, 0), e.created_at FROM orders o
+	    LEFT JOIN order_events e ON e.number = o.number
+	    WHERE o.number = $1 AND o.user_id = $2
+	    ORDER BY e.created_at, e.event_id
+	`
+
+	rows, err := ords.Stor.db.QueryContext(ctx, query, number, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := false
+	events := []models.OrderEvent{}
+	for rows.Next() {
+		found = true
+
+		var fromStatus, toStatus sql.NullString
+		var accrual models.Money
+		var at sql.NullTime
+		if err := rows.Scan(&fromStatus, &toStatus, &accrual, &at); err != nil {
+			return nil, err
+		}
+		if !toStatus.Valid {
+			continue
+		}
+
+		events = append(events, models.OrderEvent{
+			FromStatus: fromStatus.String,
+			Status:     toStatus.String,
+			Accrual:    accrual,
+			At:         at.Time,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, dberrors.ErrNotFound
+	}
+
+	return events, nil
+}
+
+func insertOrderEvent(ctx context.Context, tx *sql.Tx, number int64, fromStatus string, toStatus string, accrual models.Money, at time.Time) error {
+
+	query := `INSERT INTO order_events (number, from_status, to_status, accrual, created_at) VALUES ($1, NULLIF($2, ''), $3, NULLIF($4::DECIMAL, 0), $5)`
+
+	_, err := tx.ExecContext(ctx, query, number, fromStatus, toStatus, accrual, at)
+
+	return err
+}
@@ -0,0 +1,174 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/nu-kotov/gophermart/internal/models"
+	"github.com/nu-kotov/gophermart/internal/storage/dberrors"
+)
+
+// newTestDBStorage migrates a throwaway schema in the database from
+// TEST_DATABASE_URI and drops it after the test, so nothing a test stores
+// outlives it. The test is skipped without the variable.
+func newTestDBStorage(t *testing.T) *DBStorage {
+	t.Helper()
+
+	connString := os.Getenv("TEST_DATABASE_URI")
+	if connString == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+
+	admin, err := sql.Open("pgx", connString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := "test_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	if _, err = admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Errorf("drop schema %s: %v", schema, err)
+		}
+	})
+
+	connConfig, err := pgx.ParseConfig(connString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connConfig.RuntimeParams["search_path"] = schema
+	connName := stdlib.RegisterConnConfig(connConfig)
+	t.Cleanup(func() { stdlib.UnregisterConnConfig(connName) })
+
+	stor, err := NewConnect(connName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { stor.Close() })
+
+	return stor
+}
+
+// newTestOrdersStorage stores a fresh order of a fresh user.
+func newTestOrdersStorage(t *testing.T) (*OrdersStorage, *models.OrderData) {
+	t.Helper()
+
+	stor := newTestDBStorage(t)
+	ords := &OrdersStorage{Stor: stor}
+
+	order := &models.OrderData{
+		Number:     rand.Int64N(1 << 50),
+		UserID:     uuid.New().String(),
+		Status:     models.OrderStatusNew,
+		UploadedAt: time.Now(),
+	}
+
+	if err := ords.InsertOrderData(context.Background(), order); err != nil {
+		t.Fatal(err)
+	}
+
+	return ords, order
+}
+
+func processed(order *models.OrderData, accrual models.Money) *models.OrderData {
+	return &models.OrderData{
+		Number:  order.Number,
+		UserID:  order.UserID,
+		Status:  models.OrderStatusProcessed,
+		Accrual: accrual,
+	}
+}
+
+func balanceOf(t *testing.T, ords *OrdersStorage, userID string) models.Money {
+	t.Helper()
+
+	var balance models.Money
+	err := ords.Stor.db.QueryRow(`SELECT balance FROM users_balances WHERE user_id = $1`, userID).Scan(&balance)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return balance
+}
+
+func TestUpdateOrderCreditsOnce(t *testing.T) {
+	ctx := context.Background()
+	ords, order := newTestOrdersStorage(t)
+
+	if err := ords.UpdateOrder(ctx, processed(order, 10050)); err != nil {
+		t.Fatalf("UpdateOrder() error = %v", err)
+	}
+	if balance := balanceOf(t, ords, order.UserID); balance != 10050 {
+		t.Fatalf("balance = %s, want 100.50", balance)
+	}
+
+	err := ords.UpdateOrder(ctx, processed(order, 10050))
+	if !errors.Is(err, dberrors.ErrInvalidTransition) {
+		t.Fatalf("duplicate UpdateOrder() error = %v, want %v", err, dberrors.ErrInvalidTransition)
+	}
+	if balance := balanceOf(t, ords, order.UserID); balance != 10050 {
+		t.Fatalf("balance after duplicate = %s, want 100.50", balance)
+	}
+}
+
+func TestUpdateOrderCreditRecordBlocksRecredit(t *testing.T) {
+	ctx := context.Background()
+	ords, order := newTestOrdersStorage(t)
+
+	if err := ords.UpdateOrder(ctx, processed(order, 4000)); err != nil {
+		t.Fatalf("UpdateOrder() error = %v", err)
+	}
+
+	// Even if the order leaves PROCESSED behind the state machine's back, the
+	// credit record keeps a second delivery from paying again.
+	if _, err := ords.Stor.db.Exec(`UPDATE orders SET status = 'PROCESSING' WHERE number = $1`, order.Number); err != nil {
+		t.Fatal(err)
+	}
+	if err := ords.UpdateOrder(ctx, processed(order, 4000)); err != nil {
+		t.Fatalf("redelivered UpdateOrder() error = %v", err)
+	}
+
+	if balance := balanceOf(t, ords, order.UserID); balance != 4000 {
+		t.Fatalf("balance = %s, want 40.00", balance)
+	}
+}
+
+func TestUpdateOrderConcurrentDeliveries(t *testing.T) {
+	ctx := context.Background()
+	ords, order := newTestOrdersStorage(t)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- ords.UpdateOrder(ctx, processed(order, 2500))
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil && !errors.Is(err, dberrors.ErrInvalidTransition) {
+			t.Fatalf("UpdateOrder() error = %v", err)
+		}
+	}
+
+	if balance := balanceOf(t, ords, order.UserID); balance != 2500 {
+		t.Fatalf("balance = %s, want 25.00", balance)
+	}
+}
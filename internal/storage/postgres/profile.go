@@ -0,0 +1,82 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/nu-kotov/gophermart/internal/models"
+	"github.com/nu-kotov/gophermart/internal/storage/dberrors"
+)
+
+type queryRower interface {
+	QueryRowContext(context.Context, string, ...any) *sql.Row
+}
+
+func (usrs *UsersStorage) SelectUserProfile(ctx context.Context, userID string) (*models.UserProfile, error) {
+	return selectUserProfile(ctx, usrs.Stor.db, userID)
+}
+
+func (usrs *UsersStorage) UpdateUserProfile(ctx context.Context, userID string, update *models.UpdateProfileRequest) error {
+
+	query := `
+	    UPDATE users SET
+	        display_name = COALESCE($2, display_name),
+	        notify_order_updates = COALESCE($3, notify_order_updates),
+	        notify_security_events = COALESCE($4, notify_security_events)
+	    WHERE user_id = $1
+	`
+
+	var orderUpdates, securityEvents *bool
+	if update.Notifications != nil {
+		orderUpdates = update.Notifications.OrderUpdates
+		securityEvents = update.Notifications.SecurityEvents
+	}
+
+	result, err := usrs.Stor.db.ExecContext(ctx, query, userID, update.DisplayName, orderUpdates, securityEvents)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return dberrors.ErrNotFound
+	}
+
+	return nil
+}
+
+func selectUserProfile(ctx context.Context, db queryRower, userID string) (*models.UserProfile, error) {
+
+	var profile models.UserProfile
+
+	query := `
+	    SELECT user_id, login, display_name, roles, registered_at, last_login_at, totp_enabled,
+	        notify_order_updates, notify_security_events
+	    FROM users WHERE user_id = $1
+	`
+
+	err := db.QueryRowContext(ctx, query, userID).Scan(
+		&profile.UserID,
+		&profile.Login,
+		&profile.DisplayName,
+		arrayScanner(&profile.Roles),
+		&profile.RegisteredAt,
+		&profile.LastLoginAt,
+		&profile.TwoFactorEnabled,
+		&profile.Notifications.OrderUpdates,
+		&profile.Notifications.SecurityEvents,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, dberrors.ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &profile, nil
+}
@@ -0,0 +1,292 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/nu-kotov/gophermart/internal/auth"
+	"github.com/nu-kotov/gophermart/internal/models"
+	"github.com/nu-kotov/gophermart/internal/storage/dberrors"
+)
+
+type TokensStorage struct {
+	Stor *DBStorage
+}
+
+func (ts *TokensStorage) InsertSession(ctx context.Context, session *models.Session, token *models.RefreshToken) error {
+
+	insertSession := `
+	    INSERT INTO sessions (session_id, user_id, user_agent, ip, created_at, last_seen_at, expires_at)
+	    VALUES ($1, $2, $3, $4, $5, $5, $6);
+	`
+	insertToken := `INSERT INTO refresh_tokens (token_hash, session_id, user_id, expires_at) VALUES ($1, $2, $3, $4);`
+
+	tx, err := ts.Stor.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		insertSession,
+		session.SessionID,
+		session.UserID,
+		session.UserAgent,
+		session.IP,
+		session.CreatedAt,
+		session.ExpiresAt,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		insertToken,
+		token.TokenHash,
+		token.SessionID,
+		token.UserID,
+		token.ExpiresAt,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (ts *TokensStorage) RotateRefreshToken(ctx context.Context, oldHash string, newToken *models.RefreshToken) error {
+
+	selectToken := `
+	    SELECT rt.session_id, rt.user_id, u.login, u.roles, rt.expires_at, rt.revoked_at IS NOT NULL, s.ended_at IS NOT NULL
+	    FROM refresh_tokens rt
+	    JOIN users u ON u.user_id = rt.user_id
+	    JOIN sessions s ON s.session_id = rt.session_id
+	    WHERE rt.token_hash = $1
+	    FOR UPDATE OF rt
+	`
+	revokeToken := `UPDATE refresh_tokens SET revoked_at = now() WHERE token_hash = $1`
+	insertToken := `INSERT INTO refresh_tokens (token_hash, session_id, user_id, expires_at) VALUES ($1, $2, $3, $4);`
+	touchSession := `UPDATE sessions SET last_seen_at = now(), expires_at = $2 WHERE session_id = $1`
+
+	tx, err := ts.Stor.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var expiresAt time.Time
+	var revoked, ended bool
+	err = tx.QueryRowContext(ctx, selectToken, oldHash).Scan(
+		&newToken.SessionID,
+		&newToken.UserID,
+		&newToken.Login,
+		arrayScanner(&newToken.Roles),
+		&expiresAt,
+		&revoked,
+		&ended,
+	)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return dberrors.ErrNotFound
+		}
+		return err
+	}
+
+	if ended {
+		tx.Rollback()
+		return dberrors.ErrTokenReused
+	}
+
+	if revoked {
+		// A revoked refresh token is presented again: the session has leaked,
+		// so it is ended together with every token issued for it.
+		if err = endSession(ctx, tx, newToken.UserID, newToken.SessionID); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+		return dberrors.ErrTokenReused
+	}
+
+	if time.Now().After(expiresAt) {
+		tx.Rollback()
+		return dberrors.ErrTokenExpired
+	}
+
+	if _, err = tx.ExecContext(ctx, revokeToken, oldHash); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		insertToken,
+		newToken.TokenHash,
+		newToken.SessionID,
+		newToken.UserID,
+		newToken.ExpiresAt,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, touchSession, newToken.SessionID, newToken.ExpiresAt); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (ts *TokensStorage) SelectUserSessions(ctx context.Context, userID string) ([]models.Session, error) {
+	var data []models.Session
+
+	query := `
+	    SELECT session_id, user_agent, ip, created_at, last_seen_at, expires_at FROM sessions
+	    WHERE user_id = $1 AND ended_at IS NULL AND expires_at > now()
+	    ORDER BY last_seen_at DESC
+	`
+
+	rows, err := ts.Stor.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		session := models.Session{UserID: userID}
+
+		err := rows.Scan(
+			&session.SessionID,
+			&session.UserAgent,
+			&session.IP,
+			&session.CreatedAt,
+			&session.LastSeenAt,
+			&session.ExpiresAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		data = append(data, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (ts *TokensStorage) UpdateLastLogin(ctx context.Context, userID string, at time.Time) error {
+
+	query := `UPDATE users SET last_login_at = $1 WHERE user_id = $2`
+
+	_, err := ts.Stor.db.ExecContext(ctx, query, at, userID)
+	return err
+}
+
+func (ts *TokensStorage) EndSession(ctx context.Context, userID string, sessionID string) error {
+
+	tx, err := ts.Stor.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err = endSession(ctx, tx, userID, sessionID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (ts *TokensStorage) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+
+	deleteExpired := `DELETE FROM revoked_tokens WHERE expires_at < now()`
+	insertRevoked := `INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING;`
+
+	tx, err := ts.Stor.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, deleteExpired); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, insertRevoked, jti, expiresAt); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (ts *TokensStorage) IsRevoked(ctx context.Context, claims *auth.Claims) (bool, error) {
+
+	revokedToken := `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)`
+	revokedSession := `
+	    SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)
+	        OR NOT EXISTS (SELECT 1 FROM sessions WHERE session_id = $2 AND user_id = $3 AND ended_at IS NULL)
+	`
+
+	var revoked bool
+	var err error
+	if claims.SessionID == "" {
+		err = ts.Stor.db.QueryRowContext(ctx, revokedToken, claims.ID).Scan(&revoked)
+	} else {
+		err = ts.Stor.db.QueryRowContext(ctx, revokedSession, claims.ID, claims.SessionID, claims.UserID).Scan(&revoked)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return revoked, nil
+}
+
+func endSession(ctx context.Context, tx *sql.Tx, userID string, sessionID string) error {
+
+	endSession := `UPDATE sessions SET ended_at = now() WHERE session_id = $1 AND user_id = $2 AND ended_at IS NULL`
+	revokeTokens := `UPDATE refresh_tokens SET revoked_at = now() WHERE session_id = $1 AND revoked_at IS NULL`
+
+	result, err := tx.ExecContext(ctx, endSession, sessionID, userID)
+	if err != nil {
+		return err
+	}
+
+	ended, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if ended == 0 {
+		return dberrors.ErrNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, revokeTokens, sessionID)
+
+	return err
+}
+
+func endUserSessions(ctx context.Context, tx *sql.Tx, userID string) error {
+
+	endSessions := `UPDATE sessions SET ended_at = now() WHERE user_id = $1 AND ended_at IS NULL`
+	revokeTokens := `UPDATE refresh_tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL`
+
+	if _, err := tx.ExecContext(ctx, endSessions, userID); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, revokeTokens, userID)
+
+	return err
+}
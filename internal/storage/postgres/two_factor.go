@@ -0,0 +1,167 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/nu-kotov/gophermart/internal/models"
+	"github.com/nu-kotov/gophermart/internal/storage/dberrors"
+)
+
+func (usrs *UsersStorage) SelectTwoFactor(ctx context.Context, userID string) (*models.TwoFactor, error) {
+
+	var twoFactor models.TwoFactor
+	var secret sql.NullString
+
+	query := `SELECT totp_secret, totp_enabled, totp_last_step FROM users WHERE user_id = $1`
+
+	err := usrs.Stor.db.QueryRowContext(ctx, query, userID).Scan(&secret, &twoFactor.Enabled, &twoFactor.LastStep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, dberrors.ErrNotFound
+		}
+		return nil, err
+	}
+	twoFactor.Secret = secret.String
+
+	return &twoFactor, nil
+}
+
+func (usrs *UsersStorage) UpdateTOTPSecret(ctx context.Context, userID string, secret string) error {
+
+	query := `UPDATE users SET totp_secret = $1 WHERE user_id = $2 AND NOT totp_enabled`
+
+	result, err := usrs.Stor.db.ExecContext(ctx, query, secret, userID)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return dberrors.ErrTwoFactorEnabled
+	}
+
+	return nil
+}
+
+func (usrs *UsersStorage) EnableTwoFactor(ctx context.Context, userID string, step int64, codeHashes []string) error {
+
+	enable := `
+	    UPDATE users SET totp_enabled = true, totp_last_step = $1
+	    WHERE user_id = $2 AND NOT totp_enabled AND totp_secret IS NOT NULL
+	`
+	insertCode := `INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2);`
+
+	tx, err := usrs.Stor.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, enable, step, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if updated == 0 {
+		tx.Rollback()
+		return dberrors.ErrTwoFactorEnabled
+	}
+
+	if err = deleteRecoveryCodes(ctx, tx, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, codeHash := range codeHashes {
+		if _, err = tx.ExecContext(ctx, insertCode, userID, codeHash); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (usrs *UsersStorage) DisableTwoFactor(ctx context.Context, userID string) error {
+
+	disable := `UPDATE users SET totp_enabled = false, totp_secret = NULL, totp_last_step = 0 WHERE user_id = $1`
+
+	tx, err := usrs.Stor.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, disable, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = deleteRecoveryCodes(ctx, tx, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateTOTPStep records the time step of an accepted code. A step that is
+// not newer than the stored one means the code is being replayed.
+func (usrs *UsersStorage) UpdateTOTPStep(ctx context.Context, userID string, step int64) error {
+
+	query := `UPDATE users SET totp_last_step = $1 WHERE user_id = $2 AND totp_last_step < $1`
+
+	result, err := usrs.Stor.db.ExecContext(ctx, query, step, userID)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return dberrors.ErrTokenReused
+	}
+
+	return nil
+}
+
+func (usrs *UsersStorage) UseRecoveryCode(ctx context.Context, userID string, codeHash string) error {
+
+	query := `UPDATE recovery_codes SET used_at = now() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+
+	result, err := usrs.Stor.db.ExecContext(ctx, query, userID, codeHash)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return dberrors.ErrNotFound
+	}
+
+	return nil
+}
+
+func deleteRecoveryCodes(ctx context.Context, tx *sql.Tx, userID string) error {
+
+	query := `DELETE FROM recovery_codes WHERE user_id = $1`
+
+	_, err := tx.ExecContext(ctx, query, userID)
+
+	return err
+}
@@ -2,9 +2,14 @@ package postgres
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/nu-kotov/gophermart/internal/models"
+	"github.com/nu-kotov/gophermart/internal/storage/dberrors"
 )
 
 type UsersStorage struct {
@@ -13,7 +18,7 @@ type UsersStorage struct {
 
 func (usrs *UsersStorage) InsertUserData(ctx context.Context, data *models.UserData) error {
 
-	sql := `INSERT INTO users (login, password) VALUES ($1, $2);`
+	sql := `INSERT INTO users (user_id, login, password) VALUES ($1, $2, $3);`
 
 	tx, err := usrs.Stor.db.Begin()
 	if err != nil {
@@ -23,12 +28,19 @@ func (usrs *UsersStorage) InsertUserData(ctx context.Context, data *models.UserD
 	_, err = tx.ExecContext(
 		ctx,
 		sql,
+		data.UserID,
 		data.Login,
 		data.Password,
 	)
 
 	if err != nil {
 		tx.Rollback()
+
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation && pgErr.ConstraintName == "users_login_key" {
+			return dberrors.ErrLoginTaken
+		}
+
 		return err
 	}
 
@@ -39,18 +51,286 @@ func (usrs *UsersStorage) SelectUserData(ctx context.Context, data *models.UserD
 
 	var userData models.UserData
 
-	sql := `SELECT user_id, password from users WHERE login = $1`
+	query := `SELECT user_id, password, roles, totp_enabled from users WHERE login = $1`
 
 	row := usrs.Stor.db.QueryRowContext(
 		ctx,
-		sql,
+		query,
 		data.Login,
 	)
 
-	err := row.Scan(&userData.UserID, &userData.Password)
+	err := row.Scan(&userData.UserID, &userData.Password, arrayScanner(&userData.Roles), &userData.TwoFactorEnabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, dberrors.ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &userData, nil
+}
+
+func (usrs *UsersStorage) SelectUserByID(ctx context.Context, userID string) (*models.UserData, error) {
+
+	var userData models.UserData
+
+	query := `SELECT user_id, login, password, roles from users WHERE user_id = $1`
+
+	row := usrs.Stor.db.QueryRowContext(
+		ctx,
+		query,
+		userID,
+	)
+
+	err := row.Scan(&userData.UserID, &userData.Login, &userData.Password, arrayScanner(&userData.Roles))
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, dberrors.ErrNotFound
+		}
 		return nil, err
 	}
 
 	return &userData, nil
 }
+
+func (usrs *UsersStorage) UpdatePassword(ctx context.Context, userID string, passwordHash string) error {
+
+	tx, err := usrs.Stor.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err = updatePassword(ctx, tx, userID, passwordHash); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (usrs *UsersStorage) InsertPasswordResetToken(ctx context.Context, token *models.PasswordResetToken) error {
+
+	query := `INSERT INTO password_reset_tokens (token_hash, user_id, expires_at) VALUES ($1, $2, $3);`
+
+	_, err := usrs.Stor.db.ExecContext(
+		ctx,
+		query,
+		token.TokenHash,
+		token.UserID,
+		token.ExpiresAt,
+	)
+
+	return err
+}
+
+func (usrs *UsersStorage) ResetPassword(ctx context.Context, tokenHash string, passwordHash string) error {
+
+	consumeToken := `
+	    UPDATE password_reset_tokens SET used_at = now()
+	    WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()
+	    RETURNING user_id
+	`
+	expireOthers := `UPDATE password_reset_tokens SET used_at = now() WHERE user_id = $1 AND used_at IS NULL`
+
+	tx, err := usrs.Stor.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var userID string
+	err = tx.QueryRowContext(ctx, consumeToken, tokenHash).Scan(&userID)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return dberrors.ErrNotFound
+		}
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, expireOthers, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = updatePassword(ctx, tx, userID, passwordHash); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func updatePassword(ctx context.Context, tx *sql.Tx, userID string, passwordHash string) error {
+
+	query := `UPDATE users SET password = $1 WHERE user_id = $2`
+
+	result, err := tx.ExecContext(ctx, query, passwordHash, userID)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return dberrors.ErrNotFound
+	}
+
+	return endUserSessions(ctx, tx, userID)
+}
+
+func (usrs *UsersStorage) UpdatePasswordHash(ctx context.Context, userID string, oldHash string, newHash string) error {
+
+	query := `UPDATE users SET password = $1 WHERE user_id = $2 AND password = $3`
+
+	_, err := usrs.Stor.db.ExecContext(ctx, query, newHash, userID, oldHash)
+
+	return err
+}
+
+func (usrs *UsersStorage) UpdateUserRoles(ctx context.Context, userID string, roles []string) error {
+
+	query := `UPDATE users SET roles = $1 WHERE user_id = $2`
+
+	tx, err := usrs.Stor.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, query, roles, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if updated == 0 {
+		tx.Rollback()
+		return dberrors.ErrNotFound
+	}
+
+	// Roles travel inside access tokens, so the user's sessions are ended for
+	// the change to take effect immediately.
+	if err = endUserSessions(ctx, tx, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// BootstrapRole grants the role to the user only while nobody holds it, so
+// it can hand out the first admin but never re-grant a role taken away
+// through the API. The table lock keeps two concurrent runs from both
+// seeing no holder.
+func (usrs *UsersStorage) BootstrapRole(ctx context.Context, userID string, role string) error {
+
+	lockUsers := `LOCK TABLE users IN SHARE ROW EXCLUSIVE MODE`
+	selectHolder := `SELECT EXISTS (SELECT 1 FROM users WHERE $1 = ANY(roles))`
+	grantRole := `UPDATE users SET roles = array_append(roles, $1) WHERE user_id = $2`
+
+	tx, err := usrs.Stor.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, lockUsers); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var held bool
+	if err = tx.QueryRowContext(ctx, selectHolder, role).Scan(&held); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if held {
+		tx.Rollback()
+		return dberrors.ErrRoleHeld
+	}
+
+	result, err := tx.ExecContext(ctx, grantRole, role, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if updated == 0 {
+		tx.Rollback()
+		return dberrors.ErrNotFound
+	}
+
+	if err = endUserSessions(ctx, tx, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (usrs *UsersStorage) SelectUserByIdentity(ctx context.Context, issuer string, subject string) (*models.UserData, error) {
+
+	var userData models.UserData
+
+	query := `
+	    SELECT u.user_id, u.login, u.roles, u.totp_enabled FROM user_identities i
+	    JOIN users u ON u.user_id = i.user_id
+	    WHERE i.issuer = $1 AND i.subject = $2
+	`
+
+	err := usrs.Stor.db.QueryRowContext(ctx, query, issuer, subject).Scan(
+		&userData.UserID,
+		&userData.Login,
+		arrayScanner(&userData.Roles),
+		&userData.TwoFactorEnabled,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, dberrors.ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &userData, nil
+}
+
+func (usrs *UsersStorage) InsertUserWithIdentity(ctx context.Context, data *models.UserData, identity *models.UserIdentity) error {
+
+	insertUser := `INSERT INTO users (user_id, login, password) VALUES ($1, $2, $3);`
+	insertIdentity := `INSERT INTO user_identities (issuer, subject, user_id, email) VALUES ($1, $2, $3, $4);`
+
+	tx, err := usrs.Stor.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, insertUser, data.UserID, data.Login, data.Password)
+	if err != nil {
+		tx.Rollback()
+
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation && pgErr.ConstraintName == "users_login_key" {
+			return dberrors.ErrLoginTaken
+		}
+
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, insertIdentity, identity.Issuer, identity.Subject, data.UserID, identity.Email)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -27,7 +27,7 @@ func (ws *WithdrawalsStorage) SelectUserWithdrawals(ctx context.Context, userID
 
 	for rows.Next() {
 		var number int64
-		var sum float64
+		var sum models.Money
 		var withdrawnAt time.Time
 
 		err := rows.Scan(&number, &sum, &withdrawnAt)
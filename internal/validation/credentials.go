@@ -0,0 +1,80 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"unicode"
+
+	"github.com/nu-kotov/gophermart/internal/config"
+)
+
+const maxPasswordLength = 256
+
+type Rules struct {
+	loginPattern       *regexp.Regexp
+	passwordMinLength  int
+	passwordMinClasses int
+}
+
+func NewRules(cfg *config.Config) (*Rules, error) {
+	loginPattern, err := regexp.Compile(cfg.LoginPattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid login pattern: %w", err)
+	}
+
+	return &Rules{
+		loginPattern:       loginPattern,
+		passwordMinLength:  cfg.PasswordMinLength,
+		passwordMinClasses: cfg.PasswordMinClasses,
+	}, nil
+}
+
+func (r *Rules) ValidateLogin(login string) error {
+	if login == "" {
+		return errors.New("login is required")
+	}
+	if !r.loginPattern.MatchString(login) {
+		return fmt.Errorf("login must match %s", r.loginPattern.String())
+	}
+
+	return nil
+}
+
+// ValidatePassword checks the length and the number of character classes
+// (lower case, upper case, digits, other) used in the password.
+func (r *Rules) ValidatePassword(password string) error {
+	length := len([]rune(password))
+	if length < r.passwordMinLength {
+		return fmt.Errorf("password must be at least %d characters long", r.passwordMinLength)
+	}
+	if length > maxPasswordLength {
+		return fmt.Errorf("password must be at most %d characters long", maxPasswordLength)
+	}
+
+	var lower, upper, digit, other bool
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsDigit(c):
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	classes := 0
+	for _, used := range []bool{lower, upper, digit, other} {
+		if used {
+			classes++
+		}
+	}
+	if classes < r.passwordMinClasses {
+		return fmt.Errorf("password must mix at least %d of lower case, upper case, digits and symbols", r.passwordMinClasses)
+	}
+
+	return nil
+}